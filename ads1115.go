@@ -0,0 +1,95 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// ADS1115Address is the default i2c address of a ADS1115
+	ADS1115Address = 0x48
+	// ADS1115Channels is the number of single ended inputs
+	ADS1115Channels = 4
+	// ADS1115FullScale is the input voltage that reads as full scale
+	ADS1115FullScale = 4.096
+)
+
+const (
+	ads1115Conversion = 0x00
+	ads1115Config     = 0x01
+
+	// ads1115Start starts a single conversion and reads back as one when it is done
+	ads1115Start = 0x8000
+	// ads1115Single selects channel 0 against ground, the channel is added to it
+	ads1115Single = 0x4000
+	// ads1115PGA4096 is the +-4.096 V range
+	ads1115PGA4096 = 0x0200
+	// ads1115OneShot powers down between single conversions
+	ads1115OneShot = 0x0100
+	// ads1115Rate128 is 128 samples per second
+	ads1115Rate128 = 0x0080
+	// ads1115NoComparator disables the comparator
+	ads1115NoComparator = 0x0003
+	// ads1115ConversionTime is the time of one conversion at 128 samples per second
+	ads1115ConversionTime = 8 * time.Millisecond
+)
+
+// ADS1115 is a 4 channel 16 bit i2c adc
+type ADS1115 struct {
+	Device I2C
+}
+
+// NewADS1115 creates a new ADS1115 and checks that it answers
+func NewADS1115(device I2C) (*ADS1115, error) {
+	var config [2]byte
+	err := device.ReadReg(ads1115Config, config[:])
+	if err != nil {
+		return nil, err
+	}
+	return &ADS1115{
+		Device: device,
+	}, nil
+}
+
+// Read makes a single conversion of a channel against ground and returns it in volts
+func (a *ADS1115) Read(channel int) (float64, error) {
+	if channel < 0 || channel >= ADS1115Channels {
+		return 0, fmt.Errorf("ads1115 channel %d is out of range", channel)
+	}
+	config := uint16(ads1115Start | ads1115Single | ads1115PGA4096 | ads1115OneShot |
+		ads1115Rate128 | ads1115NoComparator)
+	config |= uint16(channel) << 12
+	err := a.Device.WriteReg(ads1115Config, byte(config>>8), byte(config))
+	if err != nil {
+		return 0, err
+	}
+	var data [2]byte
+	for i := 0; ; i++ {
+		time.Sleep(ads1115ConversionTime)
+		err = a.Device.ReadReg(ads1115Config, data[:])
+		if err != nil {
+			return 0, err
+		}
+		if data[0]&(ads1115Start>>8) != 0 {
+			break
+		}
+		if i == 3 {
+			return 0, fmt.Errorf("ads1115 conversion of channel %d timed out", channel)
+		}
+	}
+	err = a.Device.ReadReg(ads1115Conversion, data[:])
+	if err != nil {
+		return 0, err
+	}
+	raw := int16(uint16(data[0])<<8 | uint16(data[1]))
+	return float64(raw) * ADS1115FullScale / 32768, nil
+}
+
+// Close releases the device
+func (a *ADS1115) Close() error {
+	return a.Device.Close()
+}
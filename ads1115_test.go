@@ -0,0 +1,128 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+)
+
+// fakeADS1115 is an ads1115 with 16 bit registers whose single ended inputs are at fixed voltages,
+// a conversion finishes as soon as it is started unless the adc is busy
+type fakeADS1115 struct {
+	sync.Mutex
+	// Volts are the voltages at the inputs
+	Volts [ADS1115Channels]float64
+	// Busy keeps conversions from finishing
+	Busy bool
+	// Config is the last config written
+	Config     uint16
+	conversion uint16
+	closed     bool
+}
+
+// WriteReg writes a 16 bit register, writing the config with the start bit converts the selected input
+func (f *fakeADS1115) WriteReg(reg byte, data ...byte) error {
+	f.Lock()
+	defer f.Unlock()
+	if f.closed {
+		return fmt.Errorf("i2c device is closed")
+	}
+	if reg != ads1115Config || len(data) != 2 {
+		return fmt.Errorf("write of %d bytes to register %d", len(data), reg)
+	}
+	f.Config = uint16(data[0])<<8 | uint16(data[1])
+	// mux 4 to 7 are the single ended inputs
+	if mux := f.Config >> 12 & 7; f.Config&ads1115Start != 0 && mux >= 4 {
+		raw := math.Round(f.Volts[mux-4] * 32768 / ADS1115FullScale)
+		f.conversion = uint16(int16(math.Max(math.MinInt16, math.Min(math.MaxInt16, raw))))
+	}
+	return nil
+}
+
+// ReadReg reads a 16 bit register
+func (f *fakeADS1115) ReadReg(reg byte, data []byte) error {
+	f.Lock()
+	defer f.Unlock()
+	if f.closed {
+		return fmt.Errorf("i2c device is closed")
+	}
+	var value uint16
+	switch reg {
+	case ads1115Conversion:
+		value = f.conversion
+	case ads1115Config:
+		value = f.Config &^ ads1115Start
+		if !f.Busy {
+			value |= ads1115Start
+		}
+	default:
+		return fmt.Errorf("read of register %d", reg)
+	}
+	data[0], data[1] = byte(value>>8), byte(value)
+	return nil
+}
+
+// Close releases the device
+func (f *fakeADS1115) Close() error {
+	f.Lock()
+	defer f.Unlock()
+	f.closed = true
+	return nil
+}
+
+// Closed returns true once the device is closed
+func (f *fakeADS1115) Closed() bool {
+	f.Lock()
+	defer f.Unlock()
+	return f.closed
+}
+
+// SetVolts sets the voltage at an input
+func (f *fakeADS1115) SetVolts(channel int, volts float64) {
+	f.Lock()
+	defer f.Unlock()
+	f.Volts[channel] = volts
+}
+
+func TestADS1115Read(t *testing.T) {
+	device := &fakeADS1115{
+		Volts: [ADS1115Channels]float64{0, 1.5, 3.3, 5},
+	}
+	adc, err := NewADS1115(device)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the last input is past full scale and saturates
+	want := []float64{0, 1.5, 3.3, ADS1115FullScale}
+	for channel := range want {
+		volts, err := adc.Read(channel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(volts-want[channel]) > ADS1115FullScale/32768 {
+			t.Fatalf("channel %d reads %v V, want %v V", channel, volts, want[channel])
+		}
+		config := device.Config
+		if mux := int(config >> 12 & 7); mux != 4+channel {
+			t.Fatalf("channel %d selects mux %d, want %d", channel, mux, 4+channel)
+		}
+		if config&0x0e00 != ads1115PGA4096 || config&ads1115OneShot == 0 || config&ads1115Start == 0 {
+			t.Fatalf("channel %d config is 0x%04x", channel, config)
+		}
+	}
+	if _, err := adc.Read(ADS1115Channels); err == nil {
+		t.Fatal("a channel out of range was read")
+	}
+	device.Busy = true
+	if _, err := adc.Read(0); err == nil {
+		t.Fatal("a conversion that never finished was read")
+	}
+	if err := adc.Close(); err != nil || !device.Closed() {
+		t.Fatal("the device was not closed")
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// BatteryPeriod is the period at which the battery voltage is read
+	BatteryPeriod = 250 * time.Millisecond
+	// BatteryFilter is the weight of a new reading in the filtered voltage,
+	// it rides out the voltage sag of the motors starting
+	BatteryFilter = .2
+)
+
+// BatteryLevel is how much charge is left in the battery
+type BatteryLevel int
+
+const (
+	// BatteryOK is a battery above the low voltage
+	BatteryOK BatteryLevel = iota
+	// BatteryLow is a battery below the low voltage, the motor duty cycle is capped
+	BatteryLow
+	// BatteryCritical is a battery below the critical voltage, the motors are stopped
+	BatteryCritical
+)
+
+// String returns the name of the battery level
+func (b BatteryLevel) String() string {
+	switch b {
+	case BatteryOK:
+		return "ok"
+	case BatteryLow:
+		return "low"
+	case BatteryCritical:
+		return "critical"
+	}
+	return fmt.Sprintf("BatteryLevel(%d)", int(b))
+}
+
+// Battery monitors the voltage of the battery pack through an adc channel
+type Battery struct {
+	ADC     *ADS1115
+	Channel int
+	// Divider is the ratio of the pack voltage to the voltage at the adc
+	Divider float64
+	// Low and Critical are the pack voltages of the low and critical levels
+	Low, Critical float64
+
+	mutex   sync.Mutex
+	voltage float64
+	level   BatteryLevel
+	read    bool
+	done    chan struct{}
+	wait    sync.WaitGroup
+}
+
+// NewBattery creates a new battery monitor on a channel of an adc
+func NewBattery(adc *ADS1115, channel int, divider, low, critical float64) *Battery {
+	return &Battery{
+		ADC:      adc,
+		Channel:  channel,
+		Divider:  divider,
+		Low:      low,
+		Critical: critical,
+		done:     make(chan struct{}),
+	}
+}
+
+// Update reads the pack voltage into the filtered voltage, the level only ever drops
+// because the voltage recovers when the load is reduced
+func (b *Battery) Update() error {
+	volts, err := b.ADC.Read(b.Channel)
+	if err != nil {
+		return err
+	}
+	volts *= b.Divider
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if !b.read {
+		b.voltage, b.read = volts, true
+	} else {
+		b.voltage += BatteryFilter * (volts - b.voltage)
+	}
+	level := BatteryOK
+	if b.voltage < b.Critical {
+		level = BatteryCritical
+	} else if b.voltage < b.Low {
+		level = BatteryLow
+	}
+	if level > b.level {
+		b.level = level
+	}
+	return nil
+}
+
+// Start reads the battery every period until it is closed, changed is called when the level drops
+func (b *Battery) Start(period time.Duration, changed func(level BatteryLevel, voltage float64)) {
+	b.wait.Add(1)
+	go func() {
+		defer b.wait.Done()
+		t := time.NewTicker(period)
+		defer t.Stop()
+		for {
+			select {
+			case <-b.done:
+				return
+			case <-t.C:
+				last := b.Level()
+				if err := b.Update(); err != nil {
+					fmt.Println("battery", err)
+					continue
+				}
+				if level := b.Level(); level != last && changed != nil {
+					changed(level, b.Voltage())
+				}
+			}
+		}
+	}()
+}
+
+// Voltage returns the filtered pack voltage
+func (b *Battery) Voltage() float64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.voltage
+}
+
+// Level returns the battery level
+func (b *Battery) Level() BatteryLevel {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.level
+}
+
+// String returns the voltage and level for the console
+func (b *Battery) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return fmt.Sprintf("battery %.2f V %s", b.voltage, b.level)
+}
+
+// Close stops reading the battery and releases the adc
+func (b *Battery) Close() error {
+	close(b.done)
+	b.wait.Wait()
+	return b.ADC.Close()
+}
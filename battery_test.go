@@ -0,0 +1,92 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testBattery creates a battery on a fake adc with a divider of 3, low at 7 V and critical at 6.6 V
+func testBattery(t *testing.T) (*Battery, *fakeADS1115) {
+	t.Helper()
+	device := &fakeADS1115{}
+	adc, err := NewADS1115(device)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewBattery(adc, 1, 3, 7, 6.6), device
+}
+
+func TestBatteryLevels(t *testing.T) {
+	battery, device := testBattery(t)
+	tests := []struct {
+		name    string
+		pack    float64
+		updates int
+		voltage float64
+		level   BatteryLevel
+	}{
+		{"charged", 8, 1, 8, BatteryOK},
+		// the sag of the motors starting is filtered out
+		{"sag", 6, 1, 7.6, BatteryOK},
+		{"recovered", 8, 20, 8, BatteryOK},
+		{"low", 6.9, 30, 6.9, BatteryLow},
+		// the voltage recovers when the load drops but the level does not
+		{"unloaded", 7.5, 30, 7.5, BatteryLow},
+		{"critical", 6.4, 30, 6.4, BatteryCritical},
+		{"unloaded critical", 8, 30, 8, BatteryCritical},
+	}
+	for _, test := range tests {
+		device.SetVolts(1, test.pack/3)
+		for i := 0; i < test.updates; i++ {
+			if err := battery.Update(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if voltage := battery.Voltage(); math.Abs(voltage-test.voltage) > .01 {
+			t.Fatalf("%s: voltage is %v V, want %v V", test.name, voltage, test.voltage)
+		}
+		if level := battery.Level(); level != test.level {
+			t.Fatalf("%s: level is %s, want %s", test.name, level, test.level)
+		}
+	}
+}
+
+func TestBatteryClose(t *testing.T) {
+	battery, device := testBattery(t)
+	device.SetVolts(1, 8./3)
+	if err := battery.Update(); err != nil {
+		t.Fatal(err)
+	}
+	var mutex sync.Mutex
+	var changes []BatteryLevel
+	battery.Start(time.Millisecond, func(level BatteryLevel, voltage float64) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		changes = append(changes, level)
+	})
+	device.SetVolts(1, 6./3)
+	deadline := time.Now().Add(5 * time.Second)
+	for battery.Level() != BatteryCritical {
+		if time.Now().After(deadline) {
+			t.Fatal("the battery never went critical")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := battery.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !device.Closed() {
+		t.Fatal("the adc was not closed")
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(changes) != 2 || changes[0] != BatteryLow || changes[1] != BatteryCritical {
+		t.Fatalf("the level changes are %v, want low and critical", changes)
+	}
+}
@@ -0,0 +1,490 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+)
+
+const (
+	// CalibrationViews is the least number of views of the board needed to calibrate
+	CalibrationViews = 4
+	// calibrationIterations is the most iterations of the least squares refinement
+	calibrationIterations = 100
+)
+
+// ErrCalibrationViews is returned when the views of the board do not determine the calibration
+var ErrCalibrationViews = errors.New("views of the board are too few or too alike, tilt the board more between views")
+
+// CameraCalibration is the pinhole model and lens distortion of a camera
+type CameraCalibration struct {
+	// Width and Height are the size of the calibration images
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// Fx and Fy are the focal lengths in pixels
+	Fx float64 `json:"fx"`
+	Fy float64 `json:"fy"`
+	// Cx and Cy are the principal point in pixels
+	Cx float64 `json:"cx"`
+	Cy float64 `json:"cy"`
+	// Distortion is k1, k2, p1, p2 and k3 of the Brown-Conrady lens model
+	Distortion [5]float64 `json:"distortion"`
+	// Error is the rms reprojection error in pixels of the calibration
+	Error float64 `json:"error"`
+}
+
+// LoadCameraCalibration loads a camera calibration from a json file
+func LoadCameraCalibration(name string) (CameraCalibration, error) {
+	var calibration CameraCalibration
+	err := loadJSON(name, &calibration)
+	return calibration, err
+}
+
+// Save saves the camera calibration to a json file
+func (c CameraCalibration) Save(name string) error {
+	return saveJSON(name, c)
+}
+
+// Scale returns the calibration for images of another size with the same field of view
+func (c CameraCalibration) Scale(width, height int) CameraCalibration {
+	if c.Width == width && c.Height == height || c.Width == 0 || c.Height == 0 {
+		return c
+	}
+	sx, sy := float64(width)/float64(c.Width), float64(height)/float64(c.Height)
+	c.Fx, c.Cx = c.Fx*sx, c.Cx*sx
+	c.Fy, c.Cy = c.Fy*sy, c.Cy*sy
+	c.Width, c.Height = width, height
+	return c
+}
+
+// Distort applies the lens distortion to normalized image coordinates
+func (c CameraCalibration) Distort(x, y float64) (float64, float64) {
+	k1, k2, p1, p2, k3 := c.Distortion[0], c.Distortion[1], c.Distortion[2], c.Distortion[3], c.Distortion[4]
+	r2 := x*x + y*y
+	radial := 1 + r2*(k1+r2*(k2+r2*k3))
+	return x*radial + 2*p1*x*y + p2*(r2+2*x*x), y*radial + p1*(r2+2*y*y) + 2*p2*x*y
+}
+
+// Undistort removes the lens distortion from normalized image coordinates
+func (c CameraCalibration) Undistort(x, y float64) (float64, float64) {
+	k1, k2, p1, p2, k3 := c.Distortion[0], c.Distortion[1], c.Distortion[2], c.Distortion[3], c.Distortion[4]
+	ux, uy := x, y
+	for i := 0; i < 20; i++ {
+		r2 := ux*ux + uy*uy
+		radial := 1 + r2*(k1+r2*(k2+r2*k3))
+		ux = (x - 2*p1*ux*uy - p2*(r2+2*ux*ux)) / radial
+		uy = (y - p1*(r2+2*uy*uy) - 2*p2*ux*uy) / radial
+	}
+	return ux, uy
+}
+
+// Project returns the pixel of a point in the camera frame, false if it is behind the camera
+func (c CameraCalibration) Project(p vec3) (float64, float64, bool) {
+	if p[2] <= 0 {
+		return 0, 0, false
+	}
+	x, y := c.Distort(p[0]/p[2], p[1]/p[2])
+	return c.Fx*x + c.Cx, c.Fy*y + c.Cy, true
+}
+
+// StereoCalibration is the calibration of the left and right cameras, a point X in the left camera
+// frame is R X + T in the right camera frame
+type StereoCalibration struct {
+	Left  CameraCalibration `json:"left"`
+	Right CameraCalibration `json:"right"`
+	// Rotation is the row major rotation R from the left camera frame to the right camera frame
+	Rotation [9]float64 `json:"rotation"`
+	// Translation is the translation T in meters from the left camera frame to the right camera frame
+	Translation [3]float64 `json:"translation"`
+	// Error is the rms reprojection error in pixels of the calibration
+	Error float64 `json:"error"`
+}
+
+// LoadStereoCalibration loads a stereo calibration from a json file
+func LoadStereoCalibration(name string) (StereoCalibration, error) {
+	var calibration StereoCalibration
+	err := loadJSON(name, &calibration)
+	return calibration, err
+}
+
+// Save saves the stereo calibration to a json file
+func (s StereoCalibration) Save(name string) error {
+	return saveJSON(name, s)
+}
+
+// loadJSON loads a value from a json file
+func loadJSON(name string, value interface{}) error {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(data, value)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// saveJSON saves a value to a json file
+func saveJSON(name string, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, append(data, '\n'), 0644)
+}
+
+// Undistorter returns a remap that removes the lens distortion from frames of width by height,
+// the undistorted frames keep the focal length and principal point
+func (c CameraCalibration) Undistorter(width, height int) *Remap {
+	c = c.Scale(width, height)
+	return NewRemap(width, height, func(u, v int) (float64, float64, bool) {
+		x, y := c.Distort((float64(u)-c.Cx)/c.Fx, (float64(v)-c.Cy)/c.Fy)
+		return c.Fx*x + c.Cx, c.Fy*y + c.Cy, true
+	})
+}
+
+// boardPose is the rotation vector and translation in meters from the board frame to the camera frame
+type boardPose struct {
+	Rotation    vec3
+	Translation vec3
+}
+
+// transform moves a point from the board frame to the camera frame
+func (p boardPose) transform(point vec3) vec3 {
+	return rodrigues(p.Rotation).mulVec(point).add(p.Translation)
+}
+
+// normalizer returns the similarity that moves points to their centroid and scales them
+// to an average distance of the square root of 2 from it, and its inverse
+func normalizer(points []vec2) (mat3, mat3) {
+	var center vec2
+	for _, p := range points {
+		center = center.add(p)
+	}
+	center = center.scale(1 / float64(len(points)))
+	distance := 0.0
+	for _, p := range points {
+		distance += p.sub(center).norm()
+	}
+	s := math.Sqrt2 * float64(len(points)) / distance
+	return mat3{s, 0, -s * center[0], 0, s, -s * center[1], 0, 0, 1},
+		mat3{1 / s, 0, center[0], 0, 1 / s, center[1], 0, 0, 1}
+}
+
+// homography returns the homography from the board plane to the image with the normalized direct linear transform
+func homography(board []vec3, view []vec2) mat3 {
+	flat := make([]vec2, len(board))
+	for i, p := range board {
+		flat[i] = vec2{p[0], p[1]}
+	}
+	tb, _ := normalizer(flat)
+	ti, inverse := normalizer(view)
+	m := make([]float64, 81)
+	for i := range view {
+		b := tb.mulVec(vec3{flat[i][0], flat[i][1], 1})
+		v := ti.mulVec(vec3{view[i][0], view[i][1], 1})
+		for _, row := range [2][9]float64{
+			{b[0], b[1], 1, 0, 0, 0, -v[0] * b[0], -v[0] * b[1], -v[0]},
+			{0, 0, 0, b[0], b[1], 1, -v[1] * b[0], -v[1] * b[1], -v[1]},
+		} {
+			for r := 0; r < 9; r++ {
+				for c := 0; c < 9; c++ {
+					m[9*r+c] += row[r] * row[c]
+				}
+			}
+		}
+	}
+	var h mat3
+	copy(h[:], nullVector(m, 9))
+	return inverse.mul(h).mul(tb)
+}
+
+// zhang returns the focal lengths and principal point from the homographies of three or more views of a plane
+func zhang(homographies []mat3) (fx, fy, cx, cy float64, err error) {
+	m := make([]float64, 36)
+	for _, h := range homographies {
+		v := func(i, j int) [6]float64 {
+			return [6]float64{
+				h[i] * h[j],
+				h[i]*h[3+j] + h[3+i]*h[j],
+				h[3+i] * h[3+j],
+				h[6+i]*h[j] + h[i]*h[6+j],
+				h[6+i]*h[3+j] + h[3+i]*h[6+j],
+				h[6+i] * h[6+j],
+			}
+		}
+		v12, v11, v22 := v(0, 1), v(0, 0), v(1, 1)
+		var diff [6]float64
+		for k := range diff {
+			diff[k] = v11[k] - v22[k]
+		}
+		for _, row := range [2][6]float64{v12, diff} {
+			for r := 0; r < 6; r++ {
+				for c := 0; c < 6; c++ {
+					m[6*r+c] += row[r] * row[c]
+				}
+			}
+		}
+	}
+	b := nullVector(m, 6)
+	b11, b12, b22, b13, b23, b33 := b[0], b[1], b[2], b[3], b[4], b[5]
+	d := b11*b22 - b12*b12
+	cy = (b12*b13 - b11*b23) / d
+	lambda := b33 - (b13*b13+cy*(b12*b13-b11*b23))/b11
+	fx = math.Sqrt(lambda / b11)
+	fy = math.Sqrt(lambda * b11 / d)
+	cx = -b13 * fx * fx / lambda
+	for _, value := range []float64{fx, fy, cx, cy} {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return 0, 0, 0, 0, ErrCalibrationViews
+		}
+	}
+	return fx, fy, cx, cy, nil
+}
+
+// poseFromHomography returns the pose of the board from its homography and the camera intrinsics
+func poseFromHomography(c CameraCalibration, h mat3) boardPose {
+	inverse := mat3{1 / c.Fx, 0, -c.Cx / c.Fx, 0, 1 / c.Fy, -c.Cy / c.Fy, 0, 0, 1}
+	m := inverse.mul(h)
+	r1, r2, t := vec3{m[0], m[3], m[6]}, vec3{m[1], m[4], m[7]}, vec3{m[2], m[5], m[8]}
+	lambda := 2 / (r1.norm() + r2.norm())
+	if t[2] < 0 {
+		// the board is in front of the camera
+		lambda = -lambda
+	}
+	r1, r2, t = r1.scale(lambda), r2.scale(lambda), t.scale(lambda)
+	r3 := r1.cross(r2)
+	rotation := orthonormalize(mat3{r1[0], r2[0], r3[0], r1[1], r2[1], r3[1], r1[2], r2[2], r3[2]})
+	return boardPose{
+		Rotation:    rotationVector(rotation),
+		Translation: t,
+	}
+}
+
+// levenbergMarquardt minimizes the sum of the squares of m residuals by changing params,
+// the jacobian is found with finite differences
+func levenbergMarquardt(params []float64, m int, residuals func(params, r []float64)) {
+	n := len(params)
+	r, trial := make([]float64, m), make([]float64, m)
+	jacobian := make([]float64, m*n)
+	cost := func(r []float64) float64 {
+		sum := 0.0
+		for _, v := range r {
+			sum += v * v
+		}
+		return sum
+	}
+	residuals(params, r)
+	current := cost(r)
+	lambda := 1e-3
+	next := make([]float64, n)
+	for iteration := 0; iteration < calibrationIterations; iteration++ {
+		for k := range params {
+			step := 1e-6 * math.Max(1, math.Abs(params[k]))
+			saved := params[k]
+			params[k] += step
+			residuals(params, trial)
+			params[k] = saved
+			for i := range trial {
+				jacobian[i*n+k] = (trial[i] - r[i]) / step
+			}
+		}
+		jtj, jtr := make([]float64, n*n), make([]float64, n)
+		for i := 0; i < m; i++ {
+			row := jacobian[i*n : (i+1)*n]
+			for a, ja := range row {
+				if ja == 0 {
+					continue
+				}
+				jtr[a] -= ja * r[i]
+				for b := a; b < n; b++ {
+					jtj[a*n+b] += ja * row[b]
+				}
+			}
+		}
+		for a := 0; a < n; a++ {
+			for b := 0; b < a; b++ {
+				jtj[a*n+b] = jtj[b*n+a]
+			}
+		}
+		improved := false
+		for attempt := 0; attempt < 10; attempt++ {
+			a, b := append([]float64{}, jtj...), append([]float64{}, jtr...)
+			for k := 0; k < n; k++ {
+				a[k*n+k] += lambda * math.Max(jtj[k*n+k], 1e-9)
+			}
+			delta, err := solve(a, b)
+			if err != nil {
+				lambda *= 10
+				continue
+			}
+			for k := range params {
+				next[k] = params[k] + delta[k]
+			}
+			residuals(next, trial)
+			if c := cost(trial); c < current {
+				copy(params, next)
+				r, trial = trial, r
+				improved = current-c > 1e-10*current
+				current = c
+				lambda = math.Max(lambda/10, 1e-12)
+				break
+			}
+			lambda *= 10
+		}
+		if !improved {
+			return
+		}
+	}
+}
+
+// rms returns the root mean square length of the residual vectors
+func rms(r []float64) float64 {
+	sum := 0.0
+	for _, v := range r {
+		sum += v * v
+	}
+	return math.Sqrt(sum / float64(len(r)/2))
+}
+
+// reproject sets the residuals of the corners of a view seen by a camera with a board pose
+func reproject(c CameraCalibration, pose boardPose, board []vec3, view []vec2, r []float64) {
+	rotation := rodrigues(pose.Rotation)
+	for i, point := range board {
+		x, y, ok := c.Project(rotation.mulVec(point).add(pose.Translation))
+		if !ok {
+			x, y = view[i][0]+1e3, view[i][1]+1e3
+		}
+		r[2*i], r[2*i+1] = x-view[i][0], y-view[i][1]
+	}
+}
+
+// CalibrateCamera finds the intrinsics and lens distortion of a camera of width by height from views of a board,
+// k3 is left at zero
+func CalibrateCamera(board Board, views [][]vec2, width, height int) (CameraCalibration, error) {
+	calibration, _, err := calibrateCamera(board, views, width, height)
+	return calibration, err
+}
+
+// calibrateCamera finds the calibration of a camera and the pose of the board in each view
+func calibrateCamera(board Board, views [][]vec2, width, height int) (CameraCalibration, []boardPose, error) {
+	if len(views) < CalibrationViews {
+		return CameraCalibration{}, nil, ErrCalibrationViews
+	}
+	points := board.Points()
+	homographies := make([]mat3, len(views))
+	for i, view := range views {
+		homographies[i] = homography(points, view)
+	}
+	fx, fy, cx, cy, err := zhang(homographies)
+	if err != nil {
+		return CameraCalibration{}, nil, err
+	}
+	c := CameraCalibration{
+		Width:  width,
+		Height: height,
+		Fx:     fx,
+		Fy:     fy,
+		Cx:     cx,
+		Cy:     cy,
+	}
+	params := []float64{fx, fy, cx, cy, 0, 0, 0, 0}
+	for _, h := range homographies {
+		pose := poseFromHomography(c, h)
+		params = append(params, pose.Rotation[:]...)
+		params = append(params, pose.Translation[:]...)
+	}
+	unpack := func(params []float64) (CameraCalibration, []boardPose) {
+		c.Fx, c.Fy, c.Cx, c.Cy = params[0], params[1], params[2], params[3]
+		copy(c.Distortion[:4], params[4:8])
+		poses := make([]boardPose, len(views))
+		for i := range poses {
+			p := params[8+6*i:]
+			poses[i] = boardPose{
+				Rotation:    vec3{p[0], p[1], p[2]},
+				Translation: vec3{p[3], p[4], p[5]},
+			}
+		}
+		return c, poses
+	}
+	n := 2 * len(points)
+	residuals := func(params, r []float64) {
+		c, poses := unpack(params)
+		for i, view := range views {
+			reproject(c, poses[i], points, view, r[i*n:(i+1)*n])
+		}
+	}
+	levenbergMarquardt(params, n*len(views), residuals)
+	r := make([]float64, n*len(views))
+	residuals(params, r)
+	c, poses := unpack(params)
+	c.Error = rms(r)
+	return c, poses, nil
+}
+
+// CalibrateStereo calibrates the left and right cameras of width by height and finds the rotation and translation
+// between them from views of a board seen by both cameras at once
+func CalibrateStereo(board Board, left, right [][]vec2, width, height int) (StereoCalibration, error) {
+	if len(left) != len(right) {
+		return StereoCalibration{}, fmt.Errorf("%d left views and %d right views must pair up", len(left), len(right))
+	}
+	l, leftPoses, err := calibrateCamera(board, left, width, height)
+	if err != nil {
+		return StereoCalibration{}, fmt.Errorf("left camera: %w", err)
+	}
+	r, rightPoses, err := calibrateCamera(board, right, width, height)
+	if err != nil {
+		return StereoCalibration{}, fmt.Errorf("right camera: %w", err)
+	}
+	// start from the average of the transforms between the cameras in each view
+	var rotation, translation vec3
+	for i := range leftPoses {
+		rl, rr := rodrigues(leftPoses[i].Rotation), rodrigues(rightPoses[i].Rotation)
+		between := rr.mul(rl.transpose())
+		rotation = rotation.add(rotationVector(between))
+		translation = translation.add(rightPoses[i].Translation.sub(between.mulVec(leftPoses[i].Translation)))
+	}
+	rotation = rotation.scale(1 / float64(len(leftPoses)))
+	translation = translation.scale(1 / float64(len(leftPoses)))
+	params := append(append([]float64{}, rotation[:]...), translation[:]...)
+	for _, pose := range leftPoses {
+		params = append(params, pose.Rotation[:]...)
+		params = append(params, pose.Translation[:]...)
+	}
+	points := board.Points()
+	n := 2 * len(points)
+	residuals := func(params, res []float64) {
+		between := rodrigues(vec3{params[0], params[1], params[2]})
+		offset := vec3{params[3], params[4], params[5]}
+		for i := range left {
+			p := params[6+6*i:]
+			pose := boardPose{
+				Rotation:    vec3{p[0], p[1], p[2]},
+				Translation: vec3{p[3], p[4], p[5]},
+			}
+			reproject(l, pose, points, left[i], res[2*i*n:(2*i+1)*n])
+			pose = boardPose{
+				Rotation:    rotationVector(between.mul(rodrigues(pose.Rotation))),
+				Translation: between.mulVec(pose.Translation).add(offset),
+			}
+			reproject(r, pose, points, right[i], res[(2*i+1)*n:(2*i+2)*n])
+		}
+	}
+	levenbergMarquardt(params, 2*n*len(left), residuals)
+	res := make([]float64, 2*n*len(left))
+	residuals(params, res)
+	return StereoCalibration{
+		Left:        l,
+		Right:       r,
+		Rotation:    rodrigues(vec3{params[0], params[1], params[2]}),
+		Translation: vec3{params[3], params[4], params[5]},
+		Error:       rms(res),
+	}, nil
+}
@@ -0,0 +1,135 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+// syntheticViews finds the checkerboard in up to count poses seen by both synthetic stereo cameras
+func syntheticViews(t *testing.T, width, height, count int) ([][]vec2, [][]vec2) {
+	t.Helper()
+	left := NewSyntheticCamera("left", width, height, 0, 1, Scene{Pattern: PatternCheckerboard, Frames: 1})
+	right := NewSyntheticCamera("right", width, height, 0, 1, Scene{Pattern: PatternCheckerboard, Frames: 1})
+	var l, r [][]vec2
+	for n := 0; n < 10*count*SyntheticPoses && len(l) < count; n += SyntheticPoses {
+		a, ok := FindCheckerboard(left.Render(n), syntheticBoard)
+		if !ok {
+			continue
+		}
+		b, ok := FindCheckerboard(right.Render(n), syntheticBoard)
+		if !ok {
+			continue
+		}
+		l, r = append(l, a), append(r, b)
+	}
+	if len(l) < count {
+		t.Fatalf("found %d of %d views", len(l), count)
+	}
+	return l, r
+}
+
+// checkIntrinsics fails the test if the calibration is not close to the lens of the synthetic cameras
+func checkIntrinsics(t *testing.T, name string, c CameraCalibration) {
+	t.Helper()
+	truth := SyntheticCalibration(c.Width, c.Height)
+	for _, p := range []struct {
+		name      string
+		got, want float64
+		tolerance float64
+	}{
+		{"fx", c.Fx, truth.Fx, .01 * truth.Fx},
+		{"fy", c.Fy, truth.Fy, .01 * truth.Fy},
+		{"cx", c.Cx, truth.Cx, 2},
+		{"cy", c.Cy, truth.Cy, 2},
+		{"k1", c.Distortion[0], truth.Distortion[0], .03},
+	} {
+		if math.Abs(p.got-p.want) > p.tolerance {
+			t.Fatalf("%s %s is %v, want %v", name, p.name, p.got, p.want)
+		}
+	}
+	if c.Error > .5 {
+		t.Fatalf("%s reprojection error is %v px", name, c.Error)
+	}
+}
+
+func TestCalibrateCamera(t *testing.T) {
+	const width, height = 320, 240
+	left, _ := syntheticViews(t, width, height, 12)
+	c, err := CalibrateCamera(syntheticBoard, left, width, height)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("fx %.2f fy %.2f cx %.2f cy %.2f distortion %.4f error %.3f px", c.Fx, c.Fy, c.Cx, c.Cy, c.Distortion, c.Error)
+	checkIntrinsics(t, "left", c)
+	if _, err := CalibrateCamera(syntheticBoard, left[:CalibrationViews-1], width, height); err != ErrCalibrationViews {
+		t.Fatalf("too few views returned %v", err)
+	}
+}
+
+func TestCalibrateStereo(t *testing.T) {
+	const width, height = 320, 240
+	left, right := syntheticViews(t, width, height, 10)
+	s, err := CalibrateStereo(syntheticBoard, left, right, width, height)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkIntrinsics(t, "left", s.Left)
+	checkIntrinsics(t, "right", s.Right)
+	translation := vec3(s.Translation)
+	baseline := translation.norm()
+	t.Logf("translation %.4f baseline %.4f m error %.3f px", s.Translation, baseline, s.Error)
+	if math.Abs(baseline-SyntheticBaseline) > .002 {
+		t.Fatalf("baseline is %v m, want %v m", baseline, SyntheticBaseline)
+	}
+	truth := SyntheticStereoCalibration(width, height)
+	if translation.sub(vec3(truth.Translation)).norm() > .003 {
+		t.Fatalf("translation is %v, want %v", s.Translation, truth.Translation)
+	}
+	rotation := mat3(s.Rotation)
+	if angle := rotationVector(rotation).norm(); angle > .01 {
+		t.Fatalf("the cameras are rotated %v radians, want 0", angle)
+	}
+	if s.Error > .5 {
+		t.Fatalf("reprojection error is %v px", s.Error)
+	}
+	if _, err := CalibrateStereo(syntheticBoard, left, right[1:], width, height); err == nil {
+		t.Fatal("unpaired views were accepted")
+	}
+}
+
+func TestDistort(t *testing.T) {
+	c := SyntheticCalibration(320, 240)
+	c.Distortion = [5]float64{-.1, .02, .001, -.002, 0}
+	for _, p := range []vec2{{0, 0}, {.3, -.2}, {-.5, .4}, {.6, .45}} {
+		x, y := c.Distort(p[0], p[1])
+		u, v := c.Undistort(x, y)
+		if math.Abs(u-p[0]) > 1e-6 || math.Abs(v-p[1]) > 1e-6 {
+			t.Fatalf("%v distorts to %v %v and undistorts to %v %v", p, x, y, u, v)
+		}
+	}
+}
+
+func TestCalibrationSave(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "stereo.json")
+	s := SyntheticStereoCalibration(320, 240)
+	s.Error = .15
+	if err := s.Save(name); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadStereoCalibration(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded != s {
+		t.Fatalf("loaded %+v, want %+v", loaded, s)
+	}
+	half := s.Left.Scale(160, 120)
+	if half.Fx != s.Left.Fx/2 || half.Cy != s.Left.Cy/2 || half.Distortion != s.Left.Distortion {
+		t.Fatalf("half size calibration is %+v", half)
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrCameraStarted is returned when a camera is started twice
+var ErrCameraStarted = errors.New("camera is already started")
+
+// Camera is a source of video frames
+type Camera interface {
+	// Start opens the camera and streams frames until ctx is canceled or Stop is called,
+	// a camera that stopped can be started again
+	Start(ctx context.Context) error
+	// Frames is the stream of frames, it is closed when streaming ends and replaced when the camera starts again
+	Frames() <-chan Frame
+	// Stop stops streaming, waits for the camera to be released and returns the error that ended streaming
+	Stop() error
+	// Info describes the camera
+	Info() CameraInfo
+}
+
+// CameraInfo describes a camera
+type CameraInfo struct {
+	// Name is the position of the camera on the robot
+	Name string
+	// Device is the device or command the frames come from
+	Device string
+	// Format is the pixel format of the camera
+	Format string
+	// Width and Height are the size of the frames, zero until known
+	Width, Height int
+	// FPS is the frame rate, zero when unknown
+	FPS float64
+}
+
+// String returns a description of the camera
+func (c CameraInfo) String() string {
+	if c.Width == 0 || c.Height == 0 {
+		return fmt.Sprintf("%s %s", c.Name, c.Device)
+	}
+	if c.FPS == 0 {
+		return fmt.Sprintf("%s %s %s %dx%d", c.Name, c.Device, c.Format, c.Width, c.Height)
+	}
+	return fmt.Sprintf("%s %s %s %dx%d %.4g fps", c.Name, c.Device, c.Format, c.Width, c.Height, c.FPS)
+}
+
+// CameraMode is a requested frame size and rate, zero values leave the choice to the camera
+type CameraMode struct {
+	Width  int     `json:"width"`
+	Height int     `json:"height"`
+	FPS    float64 `json:"fps"`
+	// Rate is the target processing rate in Hz, frames are dropped to hold it, zero keeps every frame
+	Rate float64 `json:"rate"`
+	// Calibration is a json camera calibration file, when set the lens distortion is removed from the frames
+	Calibration string `json:"calibration"`
+}
+
+// cameraStream is the streaming life cycle shared by the cameras
+type cameraStream struct {
+	frames chan Frame
+
+	mutex    sync.Mutex
+	info     CameraInfo
+	cancel   context.CancelFunc
+	done     chan struct{}
+	err      error
+	rate     float64
+	next     time.Time
+	sequence uint64
+	captured time.Time
+
+	calibration *CameraCalibration
+	undistorter *Remap
+}
+
+// newCameraStream creates a new camera stream that sends frames at rate Hz
+func newCameraStream(info CameraInfo, rate float64) cameraStream {
+	return cameraStream{
+		frames: make(chan Frame, 1),
+		info:   info,
+		rate:   rate,
+	}
+}
+
+// begin marks the stream started and returns the context that ends it, a stream that ended starts over
+// with new frames
+func (c *cameraStream) begin(ctx context.Context) (context.Context, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.done != nil {
+		select {
+		case <-c.done:
+			c.frames, c.err = make(chan Frame, 1), nil
+		default:
+			return nil, ErrCameraStarted
+		}
+	}
+	ctx, c.cancel = context.WithCancel(ctx)
+	c.done = make(chan struct{})
+	return ctx, nil
+}
+
+// halt cancels the context of the stream
+func (c *cameraStream) halt() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
+
+// end records the error that ended streaming and closes the frames
+func (c *cameraStream) end(err error) {
+	c.halt()
+	c.mutex.Lock()
+	if err != nil && !errors.Is(err, context.Canceled) {
+		c.err = err
+	}
+	frames, done := c.frames, c.done
+	c.mutex.Unlock()
+	close(frames)
+	close(done)
+}
+
+// SetRate sets the target rate in Hz of the frames, zero sends every frame
+func (c *cameraStream) SetRate(rate float64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.rate = rate
+}
+
+// SetCalibration sets the calibration used to remove the lens distortion from the frames, nil sends them as captured
+func (c *cameraStream) SetCalibration(calibration *CameraCalibration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.calibration, c.undistorter = calibration, nil
+}
+
+// capture counts a frame captured at a time and returns false if it is dropped to hold the rate,
+// a frame a little early is kept so that a camera rate close to a multiple of the target rate is not halved
+func (c *cameraStream) capture(at time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.sequence++
+	c.captured = at
+	if c.rate <= 0 {
+		return true
+	}
+	interval := time.Duration(float64(time.Second) / c.rate)
+	if at.Before(c.next.Add(-interval / 4)) {
+		return false
+	}
+	c.next = c.next.Add(interval)
+	if c.next.Before(at) {
+		c.next = at.Add(interval)
+	}
+	return true
+}
+
+// stamp sets the source, sequence number and capture time of the last captured frame
+// and removes the lens distortion of a calibrated camera
+func (c *cameraStream) stamp(frame Frame) Frame {
+	c.mutex.Lock()
+	frame.Source, frame.Sequence, frame.Time = c.info.Name, c.sequence, c.captured
+	calibration, undistorter := c.calibration, c.undistorter
+	c.mutex.Unlock()
+	if calibration == nil || frame.Frame == nil {
+		return frame
+	}
+	b := frame.Frame.Bounds()
+	if undistorter == nil || undistorter.Width != b.Dx() || undistorter.Height != b.Dy() {
+		undistorter = calibration.Undistorter(b.Dx(), b.Dy())
+		c.mutex.Lock()
+		c.undistorter = undistorter
+		c.mutex.Unlock()
+	}
+	frame.Frame = undistorter.RGBA(frame.Frame)
+	return frame
+}
+
+// send stamps and sends a frame without blocking, the frame is dropped if the last one is unread
+func (c *cameraStream) send(frame Frame) {
+	select {
+	case c.frames <- c.stamp(frame):
+	default:
+	}
+}
+
+// setInfo updates the description of the camera
+func (c *cameraStream) setInfo(info CameraInfo) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.info = info
+}
+
+// Frames is the stream of frames, it is closed when streaming ends
+func (c *cameraStream) Frames() <-chan Frame {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.frames
+}
+
+// Info describes the camera
+func (c *cameraStream) Info() CameraInfo {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.info
+}
+
+// Stop stops streaming, waits for the camera to be released and returns the error that ended streaming
+func (c *cameraStream) Stop() error {
+	c.mutex.Lock()
+	cancel, done := c.cancel, c.done
+	c.mutex.Unlock()
+	if done == nil {
+		return nil
+	}
+	cancel()
+	<-done
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.err
+}
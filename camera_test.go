@@ -0,0 +1,138 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"image"
+	"testing"
+	"time"
+)
+
+// fakeCamera is a camera that sends the images it is given,
+// it ends with err when the images are closed
+type fakeCamera struct {
+	cameraStream
+	images chan image.Image
+	err    error
+}
+
+// newFakeCamera creates a new fake camera
+func newFakeCamera() *fakeCamera {
+	return &fakeCamera{
+		cameraStream: newCameraStream(CameraInfo{Name: "fake"}, 0),
+		images:       make(chan image.Image),
+	}
+}
+
+// Start sends the images as frames until ctx is canceled or the images are closed
+func (fc *fakeCamera) Start(ctx context.Context) error {
+	ctx, err := fc.begin(ctx)
+	if err != nil {
+		return err
+	}
+	images := fc.images
+	go func() {
+		for {
+			select {
+			case img, ok := <-images:
+				if !ok {
+					fc.end(fc.err)
+					return
+				}
+				if fc.capture(time.Now()) {
+					fc.send(Frame{Frame: img})
+				}
+			case <-ctx.Done():
+				fc.end(ctx.Err())
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// closed fails if the frames of a camera are not closed
+func closed(t *testing.T, camera Camera) {
+	t.Helper()
+	select {
+	case frame, ok := <-camera.Frames():
+		if ok {
+			t.Fatalf("frame %d arrived after the end", frame.Sequence)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the frames were not closed")
+	}
+}
+
+func TestCameraStreamRestart(t *testing.T) {
+	camera := newFakeCamera()
+	if err := camera.Stop(); err != nil {
+		t.Fatalf("a camera that was not started stopped with %v", err)
+	}
+	if err := camera.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := camera.Start(context.Background()); err != ErrCameraStarted {
+		t.Fatalf("starting twice returned %v", err)
+	}
+	camera.images <- image.NewRGBA(image.Rect(0, 0, 2, 2))
+	frame := <-camera.Frames()
+	if frame.Source != "fake" || frame.Sequence != 1 || frame.Frame == nil {
+		t.Fatalf("the frame is %s #%d", frame.Source, frame.Sequence)
+	}
+
+	// the camera is unplugged
+	unplugged := errors.New("unplugged")
+	camera.err = unplugged
+	close(camera.images)
+	closed(t, camera)
+	if err := camera.Stop(); err != unplugged {
+		t.Fatalf("stop returned %v", err)
+	}
+
+	// the camera that ended starts over with new frames and without the error
+	camera.images, camera.err = make(chan image.Image), nil
+	if err := camera.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	camera.images <- image.NewRGBA(image.Rect(0, 0, 2, 2))
+	frame, ok := <-camera.Frames()
+	if !ok || frame.Sequence != 2 {
+		t.Fatalf("the restarted camera sent frame #%d", frame.Sequence)
+	}
+	if err := camera.Stop(); err != nil {
+		t.Fatalf("stop returned %v", err)
+	}
+	closed(t, camera)
+}
+
+func TestCameraStreamStop(t *testing.T) {
+	camera := newFakeCamera()
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := camera.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// the last frame is kept until it is read, later frames are dropped
+	for i := 0; i < 3; i++ {
+		camera.images <- image.NewRGBA(image.Rect(0, 0, 2, 2))
+	}
+	frame := <-camera.Frames()
+	if frame.Sequence != 1 {
+		t.Fatalf("frame #%d was kept", frame.Sequence)
+	}
+
+	// canceling the context ends streaming without an error
+	cancel()
+	closed(t, camera)
+	// stopping again does not close the frames twice
+	for i := 0; i < 2; i++ {
+		if err := camera.Stop(); err != nil {
+			t.Fatalf("stop %d returned %v", i, err)
+		}
+	}
+	closed(t, camera)
+}
@@ -0,0 +1,492 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// checkerboardSigma is the blur in pixels applied before corners are found
+	checkerboardSigma = 1.5
+	// checkerboardSharp is the lighter blur in pixels applied before the corners are refined
+	checkerboardSharp = .7
+	// checkerboardResponse is the fraction of the strongest corner response that a corner must reach
+	checkerboardResponse = .03
+	// checkerboardContrast is the least difference in gray levels between the dark and light squares at a corner
+	checkerboardContrast = 20
+	// checkerboardSeeds is the number of the strongest corners tried as the start of the grid
+	checkerboardSeeds = 32
+)
+
+// Board is a checkerboard calibration target
+type Board struct {
+	// Columns and Rows are the number of inner corners along and across the board
+	Columns, Rows int
+	// Square is the size in meters of a square
+	Square float64
+}
+
+// ParseBoard parses the inner corners of a board such as "9x6"
+func ParseBoard(corners string, square float64) (Board, error) {
+	c, r, found := strings.Cut(corners, "x")
+	columns, err := strconv.Atoi(c)
+	if err != nil || !found {
+		return Board{}, fmt.Errorf("board %q must be columns x rows of inner corners such as 9x6", corners)
+	}
+	rows, err := strconv.Atoi(r)
+	if err != nil {
+		return Board{}, fmt.Errorf("board %q must be columns x rows of inner corners such as 9x6", corners)
+	}
+	if columns < 3 || rows < 3 {
+		return Board{}, fmt.Errorf("board %dx%d must have at least 3x3 inner corners", columns, rows)
+	}
+	if square <= 0 {
+		return Board{}, fmt.Errorf("board square %v m must be positive", square)
+	}
+	return Board{
+		Columns: columns,
+		Rows:    rows,
+		Square:  square,
+	}, nil
+}
+
+// Points returns the inner corners of the board in meters in the order of FindCheckerboard, the board is the z = 0 plane
+func (b Board) Points() []vec3 {
+	points := make([]vec3, 0, b.Columns*b.Rows)
+	for j := 0; j < b.Rows; j++ {
+		for i := 0; i < b.Columns; i++ {
+			points = append(points, vec3{float64(i) * b.Square, float64(j) * b.Square, 0})
+		}
+	}
+	return points
+}
+
+// plane is a float image
+type plane struct {
+	width, height int
+	pix           []float64
+}
+
+// at returns the value of a pixel clamped to the image
+func (p *plane) at(x, y int) float64 {
+	if x < 0 {
+		x = 0
+	} else if x >= p.width {
+		x = p.width - 1
+	}
+	if y < 0 {
+		y = 0
+	} else if y >= p.height {
+		y = p.height - 1
+	}
+	return p.pix[y*p.width+x]
+}
+
+// sample returns the bilinear interpolated value at a point
+func (p *plane) sample(q vec2) float64 {
+	x0, y0 := int(math.Floor(q[0])), int(math.Floor(q[1]))
+	fx, fy := q[0]-float64(x0), q[1]-float64(y0)
+	top := p.at(x0, y0)*(1-fx) + p.at(x0+1, y0)*fx
+	bottom := p.at(x0, y0+1)*(1-fx) + p.at(x0+1, y0+1)*fx
+	return top*(1-fy) + bottom*fy
+}
+
+// blur returns a gray image blurred with a gaussian of sigma pixels
+func blur(gray *image.Gray, sigma float64) *plane {
+	b := gray.Bounds()
+	w, h := b.Dx(), b.Dy()
+	radius := int(math.Ceil(3 * sigma))
+	kernel := make([]float64, 2*radius+1)
+	sum := 0.0
+	for i := range kernel {
+		d := float64(i - radius)
+		kernel[i] = math.Exp(-d * d / (2 * sigma * sigma))
+		sum += kernel[i]
+	}
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+	src := &plane{width: w, height: h, pix: make([]float64, w*h)}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			src.pix[y*w+x] = float64(gray.Pix[y*gray.Stride+x])
+		}
+	}
+	tmp := &plane{width: w, height: h, pix: make([]float64, w*h)}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			s := 0.0
+			for k, weight := range kernel {
+				s += weight * src.at(x+k-radius, y)
+			}
+			tmp.pix[y*w+x] = s
+		}
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			s := 0.0
+			for k, weight := range kernel {
+				s += weight * tmp.at(x, y+k-radius)
+			}
+			src.pix[y*w+x] = s
+		}
+	}
+	return src
+}
+
+// saddles returns the saddle points of an image, the corners where two dark and two light squares meet,
+// strongest first
+func saddles(p *plane) []vec2 {
+	w, h := p.width, p.height
+	response := make([]float64, w*h)
+	max := 0.0
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			xx := p.at(x+1, y) - 2*p.at(x, y) + p.at(x-1, y)
+			yy := p.at(x, y+1) - 2*p.at(x, y) + p.at(x, y-1)
+			xy := (p.at(x+1, y+1) - p.at(x-1, y+1) - p.at(x+1, y-1) + p.at(x-1, y-1)) / 4
+			// the hessian of a saddle has a negative determinant
+			if r := xy*xy - xx*yy; r > 0 {
+				response[y*w+x] = r
+				if r > max {
+					max = r
+				}
+			}
+		}
+	}
+	type corner struct {
+		point    vec2
+		response float64
+	}
+	var corners []corner
+	const radius = 3
+	for y := radius; y < h-radius; y++ {
+		for x := radius; x < w-radius; x++ {
+			r := response[y*w+x]
+			if r < checkerboardResponse*max || r == 0 {
+				continue
+			}
+			peak := true
+			for dy := -radius; dy <= radius && peak; dy++ {
+				for dx := -radius; dx <= radius; dx++ {
+					n := response[(y+dy)*w+x+dx]
+					// ties go to the first pixel in scan order
+					if n > r || n == r && (dy < 0 || dy == 0 && dx < 0) {
+						peak = false
+						break
+					}
+				}
+			}
+			if peak {
+				corners = append(corners, corner{point: vec2{float64(x), float64(y)}, response: r})
+			}
+		}
+	}
+	sort.SliceStable(corners, func(i, j int) bool {
+		return corners[i].response > corners[j].response
+	})
+	points := make([]vec2, len(corners))
+	for i, c := range corners {
+		points[i] = c.point
+	}
+	return points
+}
+
+// isCorner returns true if the squares around a point along the grid directions u and v
+// alternate between dark and light
+func isCorner(p *plane, point, u, v vec2) bool {
+	a := p.sample(point.add(u.add(v).scale(.5)))
+	b := p.sample(point.sub(u.add(v).scale(.5)))
+	c := p.sample(point.add(u.sub(v).scale(.5)))
+	d := p.sample(point.sub(u.sub(v).scale(.5)))
+	return math.Min(a, b)-math.Max(c, d) > checkerboardContrast || math.Min(c, d)-math.Max(a, b) > checkerboardContrast
+}
+
+// cell is the position of a corner in the grid
+type cell struct {
+	i, j int
+}
+
+// grid is a partly found grid of corners
+type grid struct {
+	points map[cell]vec2
+	used   map[int]bool
+	min    cell
+	max    cell
+}
+
+// add adds a corner to the grid
+func (g *grid) add(c cell, point vec2, candidate int) {
+	g.points[c] = point
+	g.used[candidate] = true
+	if len(g.points) == 1 {
+		g.min, g.max = c, c
+		return
+	}
+	g.min = cell{minInt(g.min.i, c.i), minInt(g.min.j, c.j)}
+	g.max = cell{maxInt(g.max.i, c.i), maxInt(g.max.j, c.j)}
+}
+
+// predict returns where the corner of a cell should be, extrapolated from its neighbors
+func (g *grid) predict(c cell) (vec2, bool) {
+	var sum vec2
+	n := 0
+	for _, d := range []cell{{1, 0}, {-1, 0}, {0, 1}, {0, -1}} {
+		a, ok := g.points[cell{c.i - d.i, c.j - d.j}]
+		if !ok {
+			continue
+		}
+		b, ok := g.points[cell{c.i - 2*d.i, c.j - 2*d.j}]
+		if !ok {
+			continue
+		}
+		sum, n = sum.add(a.add(a.sub(b))), n+1
+	}
+	for _, d := range []cell{{1, 1}, {1, -1}, {-1, 1}, {-1, -1}} {
+		a, ok := g.points[cell{c.i - d.i, c.j}]
+		if !ok {
+			continue
+		}
+		b, ok := g.points[cell{c.i, c.j - d.j}]
+		if !ok {
+			continue
+		}
+		m, ok := g.points[cell{c.i - d.i, c.j - d.j}]
+		if !ok {
+			continue
+		}
+		sum, n = sum.add(a.add(b).sub(m)), n+1
+	}
+	if n == 0 {
+		return vec2{}, false
+	}
+	return sum.scale(1 / float64(n)), true
+}
+
+// step returns the grid step along a direction near a cell, the average of the steps between
+// the found corners within two cells of it
+func (g *grid) step(c, direction cell) vec2 {
+	var sum vec2
+	n := 0
+	for j := c.j - 2; j <= c.j+2; j++ {
+		for i := c.i - 2; i <= c.i+2; i++ {
+			a, ok := g.points[cell{i, j}]
+			if !ok {
+				continue
+			}
+			b, ok := g.points[cell{i + direction.i, j + direction.j}]
+			if !ok {
+				continue
+			}
+			sum, n = sum.add(b.sub(a)), n+1
+		}
+	}
+	if n == 0 {
+		return vec2{}
+	}
+	return sum.scale(1 / float64(n))
+}
+
+// nearest returns the nearest unused candidate to a point within a distance
+func (g *grid) nearest(candidates []vec2, point vec2, within float64) (int, bool) {
+	best, distance := -1, within
+	for i, candidate := range candidates {
+		if g.used[i] {
+			continue
+		}
+		if d := candidate.sub(point).norm(); d < distance {
+			best, distance = i, d
+		}
+	}
+	return best, best >= 0
+}
+
+// grow grows a grid of corners from a seed candidate, the grid is at most size corners along either direction
+func grow(p *plane, candidates []vec2, seed, size int) *grid {
+	s := candidates[seed]
+	order := make([]int, 0, len(candidates))
+	for i := range candidates {
+		if i != seed {
+			order = append(order, i)
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return candidates[order[a]].sub(s).norm() < candidates[order[b]].sub(s).norm()
+	})
+	if len(order) < 4 {
+		return nil
+	}
+	// the four nearest corners are the neighbors along the grid, in opposite pairs
+	near := order[:4]
+	var pairs [][2]int
+	for _, pairing := range [][4]int{{0, 1, 2, 3}, {0, 2, 1, 3}, {0, 3, 1, 2}} {
+		a, b, c, d := near[pairing[0]], near[pairing[1]], near[pairing[2]], near[pairing[3]]
+		spacing := candidates[d].sub(s).norm()
+		if candidates[a].add(candidates[b]).sub(s.scale(2)).norm() < .25*spacing &&
+			candidates[c].add(candidates[d]).sub(s.scale(2)).norm() < .25*spacing {
+			pairs = [][2]int{{a, b}, {c, d}}
+			break
+		}
+	}
+	if pairs == nil {
+		return nil
+	}
+	u := candidates[pairs[0][0]].sub(candidates[pairs[0][1]]).scale(.5)
+	v := candidates[pairs[1][0]].sub(candidates[pairs[1][1]]).scale(.5)
+	if math.Abs(u[0]) < math.Abs(v[0]) {
+		u, v, pairs[0], pairs[1] = v, u, pairs[1], pairs[0]
+	}
+	if !isCorner(p, s, u, v) {
+		return nil
+	}
+	g := &grid{
+		points: make(map[cell]vec2),
+		used:   make(map[int]bool),
+	}
+	g.add(cell{0, 0}, s, seed)
+	// the first of each pair is one step along u or v
+	g.add(cell{1, 0}, candidates[pairs[0][0]], pairs[0][0])
+	g.add(cell{-1, 0}, candidates[pairs[0][1]], pairs[0][1])
+	g.add(cell{0, 1}, candidates[pairs[1][0]], pairs[1][0])
+	g.add(cell{0, -1}, candidates[pairs[1][1]], pairs[1][1])
+	for changed := true; changed; {
+		changed = false
+		for j := g.min.j - 1; j <= g.max.j+1; j++ {
+			for i := g.min.i - 1; i <= g.max.i+1; i++ {
+				c := cell{i, j}
+				if _, ok := g.points[c]; ok {
+					continue
+				}
+				if maxInt(g.max.i, i)-minInt(g.min.i, i) >= size || maxInt(g.max.j, j)-minInt(g.min.j, j) >= size {
+					continue
+				}
+				point, ok := g.predict(c)
+				if !ok {
+					continue
+				}
+				u, v := g.step(c, cell{1, 0}), g.step(c, cell{0, 1})
+				spacing := math.Min(u.norm(), v.norm())
+				candidate, ok := g.nearest(candidates, point, .35*spacing)
+				if !ok || !isCorner(p, candidates[candidate], u, v) {
+					continue
+				}
+				g.add(c, candidates[candidate], candidate)
+				changed = true
+			}
+		}
+	}
+	return g
+}
+
+// refine moves a corner to where the gradients around it are orthogonal to the directions to it
+func refine(p *plane, point vec2, radius int) vec2 {
+	q := point
+	for iteration := 0; iteration < 20; iteration++ {
+		cx, cy := int(math.Round(q[0])), int(math.Round(q[1]))
+		var a, b, c, bx, by float64
+		for y := cy - radius; y <= cy+radius; y++ {
+			for x := cx - radius; x <= cx+radius; x++ {
+				gx := (p.at(x+1, y) - p.at(x-1, y)) / 2
+				gy := (p.at(x, y+1) - p.at(x, y-1)) / 2
+				dx, dy := float64(x)-q[0], float64(y)-q[1]
+				weight := math.Exp(-(dx*dx + dy*dy) / float64(radius*radius))
+				xx, xy, yy := weight*gx*gx, weight*gx*gy, weight*gy*gy
+				a, b, c = a+xx, b+xy, c+yy
+				bx += xx*float64(x) + xy*float64(y)
+				by += xy*float64(x) + yy*float64(y)
+			}
+		}
+		det := a*c - b*b
+		if math.Abs(det) < 1e-9 {
+			return point
+		}
+		next := vec2{(c*bx - b*by) / det, (a*by - b*bx) / det}
+		if next.sub(point).norm() > float64(radius) {
+			return point
+		}
+		moved := next.sub(q).norm()
+		q = next
+		if moved < .01 {
+			break
+		}
+	}
+	return q
+}
+
+// FindCheckerboard finds the inner corners of a checkerboard in an image ordered along the rows from the top left,
+// false if not all of the corners are found; the board must be less than 45 degrees from upright
+func FindCheckerboard(img image.Image, board Board) ([]vec2, bool) {
+	p := blur(Gray(img), checkerboardSigma)
+	candidates := saddles(p)
+	size := maxInt(board.Columns, board.Rows)
+	for seed := 0; seed < len(candidates) && seed < checkerboardSeeds; seed++ {
+		g := grow(p, candidates, seed, size)
+		if g == nil || len(g.points) != board.Columns*board.Rows {
+			continue
+		}
+		columns, rows := g.max.i-g.min.i+1, g.max.j-g.min.j+1
+		at := func(i, j int) vec2 {
+			return g.points[cell{g.min.i + i, g.min.j + j}]
+		}
+		if columns != board.Columns {
+			if rows != board.Columns {
+				continue
+			}
+			columns, rows = rows, columns
+			at = func(i, j int) vec2 {
+				return g.points[cell{g.min.i + j, g.min.j + i}]
+			}
+		}
+		// the rows run left to right and the columns top to bottom
+		flipI := at(columns-1, 0).sub(at(0, 0))[0]+at(columns-1, rows-1).sub(at(0, rows-1))[0] < 0
+		flipJ := at(0, rows-1).sub(at(0, 0))[1]+at(columns-1, rows-1).sub(at(columns-1, 0))[1] < 0
+		spacing := math.Inf(1)
+		corners := make([]vec2, 0, columns*rows)
+		for j := 0; j < rows; j++ {
+			for i := 0; i < columns; i++ {
+				ii, jj := i, j
+				if flipI {
+					ii = columns - 1 - i
+				}
+				if flipJ {
+					jj = rows - 1 - j
+				}
+				corners = append(corners, at(ii, jj))
+				if i > 0 {
+					spacing = math.Min(spacing, corners[len(corners)-1].sub(corners[len(corners)-2]).norm())
+				}
+			}
+		}
+		radius := int(math.Max(2, math.Min(8, spacing/3)))
+		sharp := blur(Gray(img), checkerboardSharp)
+		for i, corner := range corners {
+			corners[i] = refine(sharp, corner, radius)
+		}
+		return corners, true
+	}
+	return nil, false
+}
+
+// minInt returns the smaller of a and b
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// maxInt returns the larger of a and b
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
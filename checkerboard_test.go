@@ -0,0 +1,105 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+// syntheticBoard is the board drawn by the synthetic cameras
+var syntheticBoard = Board{
+	Columns: SyntheticColumns,
+	Rows:    SyntheticRows,
+	Square:  SyntheticSquare,
+}
+
+// syntheticCorners projects the inner corners of the synthetic checkerboard in frame n into a camera
+func syntheticCorners(camera string, n, width, height int) []vec2 {
+	rotation, translation := checkerboardPose(n)
+	center := vec3{(SyntheticColumns - 1) * SyntheticSquare / 2, (SyntheticRows - 1) * SyntheticSquare / 2, 0}
+	var eye vec3
+	switch camera {
+	case "left":
+		eye[0] = -SyntheticBaseline / 2
+	case "right":
+		eye[0] = SyntheticBaseline / 2
+	}
+	lens := SyntheticCalibration(width, height)
+	var corners []vec2
+	for _, point := range syntheticBoard.Points() {
+		x, y, ok := lens.Project(rotation.mulVec(point.sub(center)).add(translation).sub(eye))
+		if ok {
+			corners = append(corners, vec2{x, y})
+		}
+	}
+	return corners
+}
+
+func TestParseBoard(t *testing.T) {
+	board, err := ParseBoard("9x6", .025)
+	if err != nil || board != (Board{Columns: 9, Rows: 6, Square: .025}) {
+		t.Fatal(board, err)
+	}
+	for _, corners := range []string{"9", "9x", "x6", "2x6", "9 by 6"} {
+		if _, err := ParseBoard(corners, .025); err == nil {
+			t.Fatalf("board %q was accepted", corners)
+		}
+	}
+	if _, err := ParseBoard("9x6", 0); err == nil {
+		t.Fatal("a zero square was accepted")
+	}
+	points := board.Points()
+	if len(points) != 54 || points[1] != (vec3{.025, 0, 0}) || points[9] != (vec3{0, .025, 0}) {
+		t.Fatal("the points are not in rows of columns")
+	}
+}
+
+func TestFindCheckerboard(t *testing.T) {
+	const width, height, poses = 320, 240, 20
+	for _, name := range []string{"left", "right"} {
+		camera := NewSyntheticCamera(name, width, height, 0, 1, Scene{Pattern: PatternCheckerboard, Frames: 1})
+		found, worst, squares, count := 0, 0.0, 0.0, 0
+		for n := 0; n < poses*SyntheticPoses; n += SyntheticPoses {
+			corners, ok := FindCheckerboard(camera.Render(n), syntheticBoard)
+			if !ok {
+				continue
+			}
+			found++
+			if len(corners) != SyntheticColumns*SyntheticRows {
+				t.Fatalf("%s frame %d: %d corners", name, n, len(corners))
+			}
+			truth := syntheticCorners(name, n, width, height)
+			for _, corner := range corners {
+				nearest := math.Inf(1)
+				for _, c := range truth {
+					nearest = math.Min(nearest, corner.sub(c).norm())
+				}
+				worst = math.Max(worst, nearest)
+				squares += nearest * nearest
+				count++
+			}
+		}
+		rms := math.Sqrt(squares / float64(count))
+		t.Logf("%s found %d of %d boards, rms %.3f px worst %.3f px", name, found, poses, rms, worst)
+		if found < poses*3/4 {
+			t.Fatalf("%s found %d of %d boards", name, found, poses)
+		}
+		if rms > .25 || worst > 1 {
+			t.Fatalf("%s corners are %.3f px rms and %.3f px worst from the truth", name, rms, worst)
+		}
+	}
+}
+
+func TestFindCheckerboardMissing(t *testing.T) {
+	camera := NewSyntheticCamera("center", 320, 240, 0, 1, Scene{Pattern: PatternBars, Frames: 1})
+	if _, ok := FindCheckerboard(camera.Render(0), syntheticBoard); ok {
+		t.Fatal("a board was found in the color bars")
+	}
+	if _, ok := FindCheckerboard(image.NewGray(image.Rect(0, 0, 320, 240)), syntheticBoard); ok {
+		t.Fatal("a board was found in a blank image")
+	}
+}
@@ -0,0 +1,727 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/warthog618/gpiod/device/rpi"
+)
+
+const (
+	// PWMSoft toggles gpio lines from goroutines
+	PWMSoft = "soft"
+	// PWMSysfs uses the hardware pwm through /sys/class/pwm for the motor enables
+	PWMSysfs = "sysfs"
+	// PWMPCA9685 uses a PCA9685 for the motor enables and the servos
+	PWMPCA9685 = "pca9685"
+	// ChipSim selects the simulated gpio backend
+	ChipSim = "sim"
+)
+
+// Profile is a hardware profile describing how a chassis is wired
+type Profile struct {
+	Name string `json:"name"`
+	// Chip is the gpio chip, sim selects the simulated gpio backend
+	Chip  string       `json:"chip"`
+	PWM   PWMProfile   `json:"pwm"`
+	Left  TrackProfile `json:"left"`
+	Right TrackProfile `json:"right"`
+	// Tilt is the up down servo of the camera head
+	Tilt ServoProfile `json:"tilt"`
+	// Pan is the left right servo of the camera head
+	Pan ServoProfile `json:"pan"`
+	// Joystick is the mapping from the joystick to the tracks
+	Joystick JoystickProfile `json:"joystick"`
+	// Actions are the track speeds of each auto mode action
+	Actions Actions `json:"actions"`
+	// Ramp limits how fast the track speeds change
+	Ramp RampProfile `json:"ramp"`
+	// Watchdog is the dead man watchdog and emergency stop
+	Watchdog WatchdogProfile `json:"watchdog"`
+	// Encoders are the optional wheel encoders
+	Encoders EncoderProfile `json:"encoders"`
+	// Ultrasonic is the optional ultrasonic rangefinder
+	Ultrasonic UltrasonicProfile `json:"ultrasonic"`
+	// IMU is the optional mpu6050 imu
+	IMU IMUProfile `json:"imu"`
+	// Battery is the optional ads1115 battery monitor
+	Battery BatteryProfile `json:"battery"`
+	// Cameras are the capture modes of the cameras
+	Cameras CamerasProfile `json:"cameras"`
+	// Stereo is the optional stereo depth of the left and right cameras
+	Stereo StereoProfile `json:"stereo"`
+}
+
+// ActionNames are the names of the auto mode actions in a profile
+var ActionNames = [...]string{
+	ActionForward:  "forward",
+	ActionLeft:     "left",
+	ActionRight:    "right",
+	ActionStop:     "stop",
+	ActionBackward: "backward",
+}
+
+// Actions are the track speeds of each auto mode action
+type Actions [5]Tracks
+
+// UnmarshalJSON decodes the actions by name over the speeds already set, so a profile only lists
+// the actions it changes
+func (a *Actions) UnmarshalJSON(data []byte) error {
+	var named map[string]json.RawMessage
+	err := json.Unmarshal(data, &named)
+	if err != nil {
+		return fmt.Errorf("actions: %w", err)
+	}
+	for name, value := range named {
+		action := -1
+		for i := range ActionNames {
+			if ActionNames[i] == name {
+				action = i
+				break
+			}
+		}
+		if action < 0 {
+			return fmt.Errorf("unknown action %q", name)
+		}
+		decoder := json.NewDecoder(bytes.NewReader(value))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&a[action])
+		if err != nil {
+			return fmt.Errorf("%s action: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// MarshalJSON encodes the actions by name
+func (a Actions) MarshalJSON() ([]byte, error) {
+	named := make(map[string]Tracks, len(a))
+	for i, tracks := range a {
+		named[ActionNames[i]] = tracks
+	}
+	return json.Marshal(named)
+}
+
+// StereoProfile is the stereo depth of the left and right cameras and the collision veto
+type StereoProfile struct {
+	Enabled bool `json:"enabled"`
+	// Calibration is the json stereo calibration file
+	Calibration string `json:"calibration"`
+	// MaxDisparity is the largest disparity searched in pixels
+	MaxDisparity int `json:"max_disparity"`
+	// Window is the odd width of the matched blocks
+	Window int `json:"window"`
+	// Uniqueness is the percent by which the best match must beat the next best match
+	Uniqueness float64 `json:"uniqueness"`
+	// Texture is the least average horizontal gradient in a block for it to be matched
+	Texture float64 `json:"texture"`
+	// Columns is the number of columns of the coarse depth
+	Columns int `json:"columns"`
+	// Top and Bottom are the rows searched for obstacles as fractions of the height
+	Top    float64 `json:"top"`
+	Bottom float64 `json:"bottom"`
+	// Threshold is the distance in meters under which auto mode will not drive forward
+	Threshold float64 `json:"threshold"`
+}
+
+// Matcher returns the block matcher of the profile
+func (s StereoProfile) Matcher() BlockMatcher {
+	return BlockMatcher{
+		MaxDisparity: s.MaxDisparity,
+		Window:       s.Window,
+		Uniqueness:   s.Uniqueness,
+		Texture:      s.Texture,
+	}
+}
+
+// CamerasProfile is the capture mode of each camera, zero sizes pick the smallest size,
+// a zero frame rate keeps the camera default and a zero processing rate keeps every frame
+type CamerasProfile struct {
+	Center CameraMode `json:"center"`
+	Left   CameraMode `json:"left"`
+	Right  CameraMode `json:"right"`
+	// Command is the command that streams h264 to its standard output for the center camera
+	Command string `json:"command"`
+	// Args are the arguments of the command, empty generates the libcamera-vid arguments from the center mode
+	Args []string `json:"args"`
+}
+
+// IMUProfile is the wiring of the imu and the behaviors that use it
+type IMUProfile struct {
+	Enabled bool `json:"enabled"`
+	// I2CBus is the i2c bus of the imu
+	I2CBus int `json:"i2c_bus"`
+	// Address is the i2c address of the imu
+	Address uint16 `json:"address"`
+	// TiltLimit is the tilt in degrees past which the tracks are emergency stopped
+	TiltLimit float64 `json:"tilt_limit"`
+	// HeadingGain is the change in track speed per degree of heading error while driving straight in manual mode
+	HeadingGain float64 `json:"heading_gain"`
+	// Straight is how close the track speeds are when driving straight
+	Straight float64 `json:"straight"`
+	// TurnAngle is the angle in degrees of the left and right actions in auto mode, zero turns open loop
+	TurnAngle float64 `json:"turn_angle"`
+	// TurnTimeout is how long in milliseconds a turn can take
+	TurnTimeout int `json:"turn_timeout"`
+}
+
+// BatteryProfile is the wiring of the battery monitor and its low voltage behaviors
+type BatteryProfile struct {
+	Enabled bool `json:"enabled"`
+	// I2CBus is the i2c bus of the adc
+	I2CBus int `json:"i2c_bus"`
+	// Address is the i2c address of the adc
+	Address uint16 `json:"address"`
+	// Channel is the adc channel of the pack voltage
+	Channel int `json:"channel"`
+	// Divider is the ratio of the pack voltage to the voltage at the adc
+	Divider float64 `json:"divider"`
+	// Low is the pack voltage below which the motor duty cycle is capped
+	Low float64 `json:"low"`
+	// LowDuty is the cap on the motor duty cycle while the battery is low
+	LowDuty float64 `json:"low_duty"`
+	// Critical is the pack voltage below which the motors are stopped and auto mode is left
+	Critical float64 `json:"critical"`
+}
+
+// UltrasonicProfile is the wiring of the ultrasonic rangefinder and the collision veto
+type UltrasonicProfile struct {
+	Enabled bool `json:"enabled"`
+	// Trigger is the trigger line
+	Trigger int `json:"trigger"`
+	// Echo is the echo line
+	Echo int `json:"echo"`
+	// Window is the number of readings in the median filter
+	Window int `json:"window"`
+	// Threshold is the distance in meters under which auto mode will not drive forward
+	Threshold float64 `json:"threshold"`
+}
+
+// EncoderProfile is the wiring and geometry of the wheel encoders
+type EncoderProfile struct {
+	Enabled bool `json:"enabled"`
+	// LeftA and LeftB are the channel lines of the left encoder, LeftB is -1 for a single channel encoder
+	LeftA int `json:"left_a"`
+	LeftB int `json:"left_b"`
+	// RightA and RightB are the channel lines of the right encoder, RightB is -1 for a single channel encoder
+	RightA int `json:"right_a"`
+	RightB int `json:"right_b"`
+	// TicksPerMeter is the number of encoder ticks per meter of track travel
+	TicksPerMeter float64 `json:"ticks_per_meter"`
+	// Width is the distance in meters between the tracks
+	Width float64 `json:"width"`
+}
+
+// WatchdogProfile is the dead man watchdog and emergency stop
+type WatchdogProfile struct {
+	// Timeout is how long in milliseconds the tracks keep running without a fresh command
+	Timeout int `json:"timeout"`
+	// EStop is the joystick button that latches the emergency stop
+	EStop int `json:"estop"`
+	// Clear is the joystick button that clears the emergency stop
+	Clear int `json:"clear"`
+}
+
+// RampProfile limits how fast the track speeds change
+type RampProfile struct {
+	// Acceleration is the largest increase in speed per second, zero is unlimited
+	Acceleration float64 `json:"acceleration"`
+	// Deceleration is the largest decrease in speed per second, zero is unlimited
+	Deceleration float64 `json:"deceleration"`
+	// Coast is how long in milliseconds a track rests before reversing
+	Coast int `json:"coast"`
+}
+
+// Limits returns the ramp limits of the profile
+func (r RampProfile) Limits() RampLimits {
+	return RampLimits{
+		Acceleration: r.Acceleration,
+		Deceleration: r.Deceleration,
+		Coast:        time.Duration(r.Coast) * time.Millisecond,
+	}
+}
+
+// JoystickProfile is the mapping from the joystick to the tracks
+type JoystickProfile struct {
+	// Mode is tank, a stick per track, or arcade, one stick for throttle and steering
+	Mode string `json:"mode"`
+	// Deadzone is the fraction of stick travel around the center that is ignored
+	Deadzone float64 `json:"deadzone"`
+	// Expo is the blend from 0 to 1 of a cubic curve for finer control near the center
+	Expo float64 `json:"expo"`
+}
+
+// PWMProfile is the pwm backend of a profile
+type PWMProfile struct {
+	// Backend is soft, sysfs or pca9685
+	Backend string `json:"backend"`
+	// SysfsChip is the pwmchip used by the sysfs backend
+	SysfsChip int `json:"sysfs_chip"`
+	// I2CBus is the i2c bus of the pca9685
+	I2CBus int `json:"i2c_bus"`
+	// Address is the i2c address of the pca9685
+	Address uint16 `json:"address"`
+	// Frequency is the pwm frequency of the pca9685 in Hz
+	Frequency float64 `json:"frequency"`
+}
+
+// TrackProfile is the wiring of one track
+type TrackProfile struct {
+	// A and B are the direction lines, A high and B low is forward
+	A int `json:"a"`
+	B int `json:"b"`
+	// Enable is the enable line used by the soft pwm, and by sysfs when the pwm chip is missing
+	Enable int `json:"enable"`
+	// Channel is the pwm channel of the enable used by the sysfs and pca9685 backends
+	Channel int `json:"channel"`
+	// Invert reverses the direction of the track
+	Invert bool `json:"invert"`
+	// Trim scales the speed of the track from 0 to 1
+	Trim float64 `json:"trim"`
+}
+
+// ServoProfile is the wiring and limits of a servo
+type ServoProfile struct {
+	// Line is the gpio line used by the soft pwm
+	Line int `json:"line"`
+	// Channel is the pca9685 channel
+	Channel int `json:"channel"`
+	// Invert reverses the direction of travel
+	Invert bool `json:"invert"`
+	// Min is the shortest pulse in microseconds
+	Min int `json:"min"`
+	// Max is the longest pulse in microseconds
+	Max int `json:"max"`
+	// Range is the travel in degrees between the shortest and longest pulse
+	Range float64 `json:"range"`
+	// Slew is the largest change in pulse width in microseconds per second
+	Slew int `json:"slew"`
+}
+
+// Limits returns the servo limits of the profile
+func (s ServoProfile) Limits() ServoLimits {
+	return ServoLimits{
+		Min:    time.Duration(s.Min) * time.Microsecond,
+		Max:    time.Duration(s.Max) * time.Microsecond,
+		Range:  s.Range,
+		Slew:   time.Duration(s.Slew) * time.Microsecond,
+		Invert: s.Invert,
+	}
+}
+
+// DefaultProfile returns the profile of the Yahboom G1 tank
+func DefaultProfile() Profile {
+	servo := func(line, channel int) ServoProfile {
+		return ServoProfile{
+			Line:    line,
+			Channel: channel,
+			Min:     int(DefaultServoLimits.Min / time.Microsecond),
+			Max:     int(DefaultServoLimits.Max / time.Microsecond),
+			Range:   DefaultServoLimits.Range,
+			Slew:    int(DefaultServoLimits.Slew / time.Microsecond),
+		}
+	}
+	return Profile{
+		Name: "yahboom g1",
+		Chip: "gpiochip0",
+		PWM: PWMProfile{
+			Backend:   PWMSoft,
+			SysfsChip: 0,
+			I2CBus:    1,
+			Address:   PCA9685Address,
+			Frequency: PCA9685Frequency,
+		},
+		Left: TrackProfile{
+			A:       rpi.GPIO20,
+			B:       rpi.GPIO21,
+			Enable:  rpi.GPIO16,
+			Channel: 0,
+			Trim:    1,
+		},
+		Right: TrackProfile{
+			A:       rpi.GPIO19,
+			B:       rpi.GPIO26,
+			Enable:  rpi.GPIO13,
+			Channel: 1,
+			Trim:    1,
+		},
+		Tilt: servo(rpi.GPIO9, 2),
+		Pan:  servo(rpi.GPIO11, 3),
+		Joystick: JoystickProfile{
+			Mode:     DriveTank,
+			Deadzone: .1,
+			Expo:     .3,
+		},
+		Actions: Actions{
+			ActionForward:  {Left: 1, Right: 1},
+			ActionLeft:     {Left: -1, Right: 1},
+			ActionRight:    {Left: 1, Right: -1},
+			ActionStop:     {Left: 0, Right: 0},
+			ActionBackward: {Left: -1, Right: -1},
+		},
+		Ramp: RampProfile{
+			Acceleration: 2,
+			Deceleration: 4,
+			Coast:        100,
+		},
+		Watchdog: WatchdogProfile{
+			Timeout: 500,
+			EStop:   2,
+			Clear:   7,
+		},
+		Encoders: EncoderProfile{
+			LeftA:  -1,
+			LeftB:  -1,
+			RightA: -1,
+			RightB: -1,
+			Width:  .19,
+		},
+		Ultrasonic: UltrasonicProfile{
+			// GPIO1 and GPIO0 are the id eeprom pins, the rpi package starts at GPIO2
+			Trigger:   1,
+			Echo:      0,
+			Window:    5,
+			Threshold: .3,
+		},
+		IMU: IMUProfile{
+			I2CBus:      1,
+			Address:     MPU6050Address,
+			TiltLimit:   45,
+			HeadingGain: .02,
+			Straight:    .1,
+			TurnAngle:   30,
+			TurnTimeout: 3000,
+		},
+		Battery: BatteryProfile{
+			I2CBus:   1,
+			Address:  ADS1115Address,
+			Channel:  0,
+			Divider:  3,
+			Low:      7,
+			LowDuty:  .5,
+			Critical: 6.6,
+		},
+		Cameras: CamerasProfile{
+			Center:  CameraMode{Rate: 10},
+			Left:    CameraMode{Rate: 10},
+			Right:   CameraMode{Rate: 10},
+			Command: "libcamera-vid",
+		},
+		Stereo: StereoProfile{
+			Calibration:  "stereo.json",
+			MaxDisparity: 48,
+			Window:       9,
+			Uniqueness:   15,
+			Texture:      2,
+			Columns:      16,
+			Top:          .25,
+			Bottom:       .75,
+			Threshold:    .3,
+		},
+	}
+}
+
+// LoadProfile loads a profile from a json file, missing settings are taken from the default profile
+func LoadProfile(name string) (Profile, error) {
+	profile := DefaultProfile()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return profile, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&profile)
+	if err != nil {
+		return profile, fmt.Errorf("%s: %w", name, err)
+	}
+	err = profile.Validate()
+	if err != nil {
+		return profile, fmt.Errorf("%s: %w", name, err)
+	}
+	return profile, nil
+}
+
+// Validate checks that a profile is usable
+func (p *Profile) Validate() error {
+	if p.Chip == "" {
+		return fmt.Errorf("chip is not set")
+	}
+	lines := make(map[int]string)
+	line := func(name string, offset int) error {
+		if offset < 0 {
+			return fmt.Errorf("%s line %d is negative", name, offset)
+		}
+		if other, ok := lines[offset]; ok {
+			return fmt.Errorf("%s line %d is already used by %s", name, offset, other)
+		}
+		lines[offset] = name
+		return nil
+	}
+	channels := make(map[int]string)
+	channel := func(name string, channel int) error {
+		if channel < 0 {
+			return fmt.Errorf("%s channel %d is negative", name, channel)
+		}
+		if p.PWM.Backend == PWMPCA9685 && channel >= PCA9685Channels {
+			return fmt.Errorf("%s channel %d is out of range for the pca9685", name, channel)
+		}
+		if other, ok := channels[channel]; ok {
+			return fmt.Errorf("%s channel %d is already used by %s", name, channel, other)
+		}
+		channels[channel] = name
+		return nil
+	}
+	addresses := make(map[[2]int]string)
+	address := func(name string, bus int, address uint16) error {
+		if address > 0x7f {
+			return fmt.Errorf("%s address 0x%x is not a 7 bit i2c address", name, address)
+		}
+		key := [2]int{bus, int(address)}
+		if other, ok := addresses[key]; ok {
+			return fmt.Errorf("%s address 0x%x on bus %d is already used by %s", name, address, bus, other)
+		}
+		addresses[key] = name
+		return nil
+	}
+
+	switch p.PWM.Backend {
+	case PWMSoft, PWMSysfs:
+	case PWMPCA9685:
+		if p.PWM.Frequency < 24 || p.PWM.Frequency > 1526 {
+			return fmt.Errorf("pca9685 frequency %v Hz must be between 24 Hz and 1526 Hz", p.PWM.Frequency)
+		}
+		if err := address("pca9685", p.PWM.I2CBus, p.PWM.Address); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("pwm backend %q must be %s, %s or %s", p.PWM.Backend, PWMSoft, PWMSysfs, PWMPCA9685)
+	}
+
+	for _, track := range []struct {
+		name  string
+		track TrackProfile
+	}{{"left", p.Left}, {"right", p.Right}} {
+		name, t := track.name, track.track
+		if err := line(name+" a", t.A); err != nil {
+			return err
+		}
+		if err := line(name+" b", t.B); err != nil {
+			return err
+		}
+		// sysfs falls back to soft pwm on the enable line when the channel can not be exported
+		if p.PWM.Backend != PWMPCA9685 {
+			if err := line(name+" enable", t.Enable); err != nil {
+				return err
+			}
+		}
+		if p.PWM.Backend != PWMSoft {
+			if err := channel(name+" enable", t.Channel); err != nil {
+				return err
+			}
+		}
+		if t.Trim <= 0 || t.Trim > 1 {
+			return fmt.Errorf("%s trim %v must be greater than 0 and at most 1", name, t.Trim)
+		}
+	}
+
+	period := ServoPeriod
+	if p.PWM.Backend == PWMPCA9685 {
+		// the servos share the period of the pca9685
+		period = time.Duration(float64(time.Second) / p.PWM.Frequency)
+	}
+	for _, servo := range []struct {
+		name  string
+		servo ServoProfile
+	}{{"tilt", p.Tilt}, {"pan", p.Pan}} {
+		name, s := servo.name, servo.servo
+		if p.PWM.Backend == PWMPCA9685 {
+			if err := channel(name, s.Channel); err != nil {
+				return err
+			}
+		} else if err := line(name, s.Line); err != nil {
+			return err
+		}
+		if s.Min <= 0 || s.Max <= s.Min {
+			return fmt.Errorf("%s pulse range %d us to %d us is invalid", name, s.Min, s.Max)
+		}
+		if time.Duration(s.Max)*time.Microsecond >= period {
+			return fmt.Errorf("%s max pulse %d us must be shorter than the %v period", name, s.Max, period)
+		}
+		if s.Range <= 0 {
+			return fmt.Errorf("%s range %v degrees must be positive", name, s.Range)
+		}
+		if s.Slew < 0 {
+			return fmt.Errorf("%s slew %d us/s is negative", name, s.Slew)
+		}
+	}
+
+	switch p.Joystick.Mode {
+	case DriveTank, DriveArcade:
+	default:
+		return fmt.Errorf("joystick mode %q must be %s or %s", p.Joystick.Mode, DriveTank, DriveArcade)
+	}
+	if p.Joystick.Deadzone < 0 || p.Joystick.Deadzone >= 1 {
+		return fmt.Errorf("joystick deadzone %v must be at least 0 and less than 1", p.Joystick.Deadzone)
+	}
+	if p.Joystick.Expo < 0 || p.Joystick.Expo > 1 {
+		return fmt.Errorf("joystick expo %v must be from 0 to 1", p.Joystick.Expo)
+	}
+	for a, tracks := range p.Actions {
+		if math.Abs(tracks.Left) > 1 || math.Abs(tracks.Right) > 1 {
+			return fmt.Errorf("%s action track speeds %v and %v must be from -1 to 1", ActionNames[a], tracks.Left, tracks.Right)
+		}
+		if Action(a) != ActionStop && tracks == (Tracks{}) {
+			return fmt.Errorf("%s action does not move the tracks", ActionNames[a])
+		}
+	}
+	if p.Ramp.Acceleration < 0 || p.Ramp.Deceleration < 0 {
+		return fmt.Errorf("ramp acceleration %v and deceleration %v must not be negative", p.Ramp.Acceleration, p.Ramp.Deceleration)
+	}
+	if p.Ramp.Coast < 0 {
+		return fmt.Errorf("ramp coast %d ms is negative", p.Ramp.Coast)
+	}
+	if p.Watchdog.Timeout <= 0 {
+		return fmt.Errorf("watchdog timeout %d ms must be positive", p.Watchdog.Timeout)
+	}
+	buttons := map[int]string{0: "mode", 1: "speed", 4: "trim", 5: "trim"}
+	for _, button := range []struct {
+		name   string
+		button int
+	}{{"estop", p.Watchdog.EStop}, {"clear", p.Watchdog.Clear}} {
+		if other, ok := buttons[button.button]; ok {
+			return fmt.Errorf("%s button %d is already used by %s", button.name, button.button, other)
+		}
+		buttons[button.button] = button.name
+	}
+	if p.Encoders.Enabled {
+		e := p.Encoders
+		if err := line("left encoder a", e.LeftA); err != nil {
+			return err
+		}
+		if e.LeftB >= 0 {
+			if err := line("left encoder b", e.LeftB); err != nil {
+				return err
+			}
+		}
+		if err := line("right encoder a", e.RightA); err != nil {
+			return err
+		}
+		if e.RightB >= 0 {
+			if err := line("right encoder b", e.RightB); err != nil {
+				return err
+			}
+		}
+		if e.TicksPerMeter <= 0 {
+			return fmt.Errorf("encoder ticks per meter %v must be positive", e.TicksPerMeter)
+		}
+		if e.Width <= 0 {
+			return fmt.Errorf("encoder track width %v m must be positive", e.Width)
+		}
+	}
+	if p.Ultrasonic.Enabled {
+		u := p.Ultrasonic
+		if err := line("ultrasonic trigger", u.Trigger); err != nil {
+			return err
+		}
+		if err := line("ultrasonic echo", u.Echo); err != nil {
+			return err
+		}
+		if u.Window < 1 {
+			return fmt.Errorf("ultrasonic window %d must be at least 1", u.Window)
+		}
+		if u.Threshold < 0 || u.Threshold > RangefinderMax {
+			return fmt.Errorf("ultrasonic threshold %v m must be from 0 m to %v m", u.Threshold, RangefinderMax)
+		}
+	}
+	if p.IMU.Enabled {
+		i := p.IMU
+		if err := address("imu", i.I2CBus, i.Address); err != nil {
+			return err
+		}
+		if i.TiltLimit <= 0 || i.TiltLimit > 180 {
+			return fmt.Errorf("imu tilt limit %v degrees must be greater than 0 and at most 180", i.TiltLimit)
+		}
+		if i.HeadingGain < 0 || i.Straight < 0 {
+			return fmt.Errorf("imu heading gain %v and straight %v must not be negative", i.HeadingGain, i.Straight)
+		}
+		if i.TurnAngle < 0 || i.TurnAngle >= 180 {
+			return fmt.Errorf("imu turn angle %v degrees must be at least 0 and less than 180", i.TurnAngle)
+		}
+		if i.TurnTimeout <= 0 {
+			return fmt.Errorf("imu turn timeout %d ms must be positive", i.TurnTimeout)
+		}
+	}
+	for name, mode := range map[string]CameraMode{
+		"center": p.Cameras.Center,
+		"left":   p.Cameras.Left,
+		"right":  p.Cameras.Right,
+	} {
+		if mode.Width < 0 || mode.Height < 0 || mode.FPS < 0 || mode.Rate < 0 {
+			return fmt.Errorf("%s camera mode %dx%d at %v fps and %v Hz must not be negative",
+				name, mode.Width, mode.Height, mode.FPS, mode.Rate)
+		}
+		if (mode.Width == 0) != (mode.Height == 0) {
+			return fmt.Errorf("%s camera width %d and height %d must both be set", name, mode.Width, mode.Height)
+		}
+	}
+	if p.Cameras.Command == "" {
+		return fmt.Errorf("center camera command must be set")
+	}
+	if p.Battery.Enabled {
+		b := p.Battery
+		if err := address("battery", b.I2CBus, b.Address); err != nil {
+			return err
+		}
+		if b.Channel < 0 || b.Channel >= ADS1115Channels {
+			return fmt.Errorf("battery channel %d must be from 0 to %d", b.Channel, ADS1115Channels-1)
+		}
+		if b.Divider < 1 {
+			return fmt.Errorf("battery divider %v must be at least 1", b.Divider)
+		}
+		if b.Critical <= 0 || b.Critical >= b.Low {
+			return fmt.Errorf("battery critical %v V must be positive and below low %v V", b.Critical, b.Low)
+		}
+		if b.Low >= ADS1115FullScale*b.Divider {
+			return fmt.Errorf("battery low %v V is past the %v V range of the divider", b.Low, ADS1115FullScale*b.Divider)
+		}
+		if b.LowDuty <= 0 || b.LowDuty > 1 {
+			return fmt.Errorf("battery low duty %v must be greater than 0 and at most 1", b.LowDuty)
+		}
+	}
+	if p.Stereo.Enabled {
+		s := p.Stereo
+		if s.Calibration == "" {
+			return fmt.Errorf("stereo calibration file must be set")
+		}
+		if s.MaxDisparity < 1 {
+			return fmt.Errorf("stereo max disparity %d must be at least 1", s.MaxDisparity)
+		}
+		if s.Window < 3 || s.Window%2 == 0 {
+			return fmt.Errorf("stereo window %d must be odd and at least 3", s.Window)
+		}
+		if s.Uniqueness < 0 || s.Texture < 0 {
+			return fmt.Errorf("stereo uniqueness %v and texture %v must not be negative", s.Uniqueness, s.Texture)
+		}
+		if s.Columns < 3 {
+			return fmt.Errorf("stereo columns %d must be at least 3", s.Columns)
+		}
+		if s.Top < 0 || s.Top >= s.Bottom || s.Bottom > 1 {
+			return fmt.Errorf("stereo top %v and bottom %v must be fractions with top above bottom", s.Top, s.Bottom)
+		}
+		if s.Threshold < 0 {
+			return fmt.Errorf("stereo threshold %v m must not be negative", s.Threshold)
+		}
+		if p.Cameras.Left.Calibration != "" || p.Cameras.Right.Calibration != "" {
+			return fmt.Errorf("stereo rectification removes the lens distortion, the left and right camera calibrations must not be set")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// loadProfile loads a profile from json text
+func loadProfile(t *testing.T, text string) (Profile, error) {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "profile.json")
+	if err := os.WriteFile(name, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return LoadProfile(name)
+}
+
+func TestLoadActions(t *testing.T) {
+	// only the left action and the right speed of the backward action change
+	profile, err := loadProfile(t, `{"actions": {"left": {"left": 0.5, "right": 1}, "backward": {"right": -0.5}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := DefaultProfile().Actions
+	want[ActionLeft] = Tracks{Left: .5, Right: 1}
+	want[ActionBackward].Right = -.5
+	if profile.Actions != want {
+		t.Fatalf("the actions are %v, want %v", profile.Actions, want)
+	}
+
+	for _, text := range []string{
+		`{"actions": {"sideways": {"left": 1, "right": 1}}}`,
+		`{"actions": {"forward": {"left": 1, "right": 1, "up": 1}}}`,
+		`{"actions": [{"left": 1, "right": 1}]}`,
+		`{"actions": {"forward": {"left": 2, "right": 1}}}`,
+		// an action that does not move would leave auto mode stuck
+		`{"actions": {"forward": {"left": 0, "right": 0}}}`,
+	} {
+		if _, err := loadProfile(t, text); err == nil {
+			t.Fatalf("the profile %s was accepted", text)
+		}
+	}
+	if _, err := loadProfile(t, `{"actions": {"stop": {"left": 0, "right": 0}}}`); err != nil {
+		t.Fatal(err)
+	}
+}
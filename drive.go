@@ -0,0 +1,244 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+	"sync"
+)
+
+// DifferentialDrive is a drive with a left and a right track
+type DifferentialDrive interface {
+	// SetTracks sets the speed of the left and right tracks from -1 to 1
+	SetTracks(left, right float64) error
+	// Stop stops both tracks
+	Stop() error
+	// Close stops both tracks and releases the drive
+	Close() error
+}
+
+// Line is a digital output line
+type Line interface {
+	SetValue(value int) error
+	Close() error
+}
+
+// Tracks is a pair of left and right track speeds
+type Tracks struct {
+	Left  float64 `json:"left"`
+	Right float64 `json:"right"`
+}
+
+// clamp limits a track speed to the range -1 to 1
+func clamp(speed float64) float64 {
+	if speed > 1 {
+		return 1
+	} else if speed < -1 {
+		return -1
+	}
+	return speed
+}
+
+// Motor is one track of a L298N
+type Motor struct {
+	// A and B are the direction lines, A high and B low is forward
+	A, B Line
+	// Enable is the speed of the track
+	Enable PWM
+	// Invert reverses the direction of the track
+	Invert bool
+	// Trim scales the speed of the track
+	Trim float64
+
+	speed float64
+}
+
+// set sets the direction lines and the enable duty cycle of the motor, the duty cycle is at most limit
+func (m *Motor) set(speed, limit float64) error {
+	m.speed = speed
+	if m.Invert {
+		speed = -speed
+	}
+	if err := m.Enable.SetDuty(math.Min(math.Abs(speed)*m.Trim, limit)); err != nil {
+		return err
+	}
+	a, b := 0, 0
+	if speed > 0 {
+		a = 1
+	} else if speed < 0 {
+		b = 1
+	}
+	if err := m.A.SetValue(a); err != nil {
+		return err
+	}
+	return m.B.SetValue(b)
+}
+
+// Close releases the lines of the motor
+func (m *Motor) Close() error {
+	err := m.Enable.Close()
+	for _, line := range []Line{m.A, m.B} {
+		if e := line.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// L298N is a differential drive using a L298N dual h-bridge, each track has its own speed
+type L298N struct {
+	Left  Motor
+	Right Motor
+
+	mutex sync.Mutex
+	limit float64
+}
+
+// NewL298N creates a new L298N drive, in1 and in2 drive the left track
+// and in3 and in4 drive the right track
+func NewL298N(in1, in2, in3, in4 Line, ena, enb PWM) *L298N {
+	return NewL298NMotors(Motor{
+		A:      in1,
+		B:      in2,
+		Enable: ena,
+		Trim:   1,
+	}, Motor{
+		A:      in3,
+		B:      in4,
+		Enable: enb,
+		Trim:   1,
+	})
+}
+
+// NewL298NMotors creates a new L298N drive from the left and right motors at full duty limit
+func NewL298NMotors(left, right Motor) *L298N {
+	return &L298N{
+		Left:  left,
+		Right: right,
+		limit: 1,
+	}
+}
+
+// SetTracks sets the speed of the left and right tracks
+func (l *L298N) SetTracks(left, right float64) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if err := l.Left.set(clamp(left), l.limit); err != nil {
+		return err
+	}
+	return l.Right.set(clamp(right), l.limit)
+}
+
+// Tracks returns the commanded speed of the left and right tracks
+func (l *L298N) Tracks() Tracks {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return Tracks{
+		Left:  l.Left.speed,
+		Right: l.Right.speed,
+	}
+}
+
+// Trim returns the trim of the left and right tracks
+func (l *L298N) Trim() (left, right float64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.Left.Trim, l.Right.Trim
+}
+
+// SetTrim sets the trim of the left and right tracks, it takes effect on the next command
+func (l *L298N) SetTrim(left, right float64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.Left.Trim, l.Right.Trim = clampDuty(left), clampDuty(right)
+}
+
+// Limit returns the cap on the duty cycle of both tracks
+func (l *L298N) Limit() float64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.limit
+}
+
+// SetLimit caps the duty cycle of both tracks, it takes effect immediately
+func (l *L298N) SetLimit(limit float64) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.limit = clampDuty(limit)
+	if err := l.Left.set(l.Left.speed, l.limit); err != nil {
+		return err
+	}
+	return l.Right.set(l.Right.speed, l.limit)
+}
+
+// Balance shifts trim between the tracks, a positive shift slows the left track
+// or speeds up the right track so that the faster track is always trimmed
+func Balance(left, right, shift float64) (float64, float64) {
+	balance := right - left + shift
+	if balance > 0 {
+		return clampDuty(1 - balance), 1
+	}
+	return 1, clampDuty(1 + balance)
+}
+
+// Stop stops both tracks
+func (l *L298N) Stop() error {
+	return l.SetTracks(0, 0)
+}
+
+// Close stops both tracks and releases the lines
+func (l *L298N) Close() error {
+	err := l.Stop()
+	if e := l.Left.Close(); err == nil {
+		err = e
+	}
+	if e := l.Right.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// FakeDrive is an in memory differential drive
+type FakeDrive struct {
+	sync.Mutex
+	Tracks
+	History []Tracks
+	Closed  bool
+}
+
+// NewFakeDrive creates a new in memory drive
+func NewFakeDrive() *FakeDrive {
+	return &FakeDrive{}
+}
+
+// SetTracks records the speed of the left and right tracks
+func (f *FakeDrive) SetTracks(left, right float64) error {
+	f.Lock()
+	defer f.Unlock()
+	f.Left, f.Right = clamp(left), clamp(right)
+	f.History = append(f.History, f.Tracks)
+	return nil
+}
+
+// Stop stops both tracks
+func (f *FakeDrive) Stop() error {
+	return f.SetTracks(0, 0)
+}
+
+// Close stops both tracks and marks the drive as closed
+func (f *FakeDrive) Close() error {
+	err := f.Stop()
+	f.Lock()
+	f.Closed = true
+	f.Unlock()
+	return err
+}
+
+// Current returns the current track speeds
+func (f *FakeDrive) Current() Tracks {
+	f.Lock()
+	defer f.Unlock()
+	return f.Tracks
+}
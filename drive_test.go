@@ -0,0 +1,127 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+)
+
+// simL298N creates a L298N on simulated lines wired like the default profile
+func simL298N(t *testing.T) (*L298N, *SimGPIO, Profile) {
+	t.Helper()
+	profile := DefaultProfile()
+	gpio := NewSimGPIO()
+	output := func(offset int) Line {
+		line, err := gpio.Output(offset, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return line
+	}
+	l, r := profile.Left, profile.Right
+	drive := NewL298N(output(l.A), output(l.B), output(r.A), output(r.B),
+		NewSoftPWM(output(l.Enable), SoftPWMPeriod), NewSoftPWM(output(r.Enable), SoftPWMPeriod))
+	return drive, gpio, profile
+}
+
+func TestL298NActions(t *testing.T) {
+	drive, gpio, profile := simL298N(t)
+	defer drive.Close()
+	l, r := profile.Left, profile.Right
+	// the direction lines of the left and right tracks, A high and B low is forward
+	tests := []struct {
+		action Action
+		lines  [4]int
+	}{
+		{ActionForward, [4]int{1, 0, 1, 0}},
+		{ActionLeft, [4]int{0, 1, 1, 0}},
+		{ActionRight, [4]int{1, 0, 0, 1}},
+		{ActionStop, [4]int{0, 0, 0, 0}},
+		{ActionBackward, [4]int{0, 1, 0, 1}},
+	}
+	for _, test := range tests {
+		tracks := profile.Actions[test.action]
+		if err := drive.SetTracks(tracks.Left, tracks.Right); err != nil {
+			t.Fatal(err)
+		}
+		lines := [4]int{gpio.Value(l.A), gpio.Value(l.B), gpio.Value(r.A), gpio.Value(r.B)}
+		if lines != test.lines {
+			t.Fatalf("action %d sets the lines to %v, want %v", test.action, lines, test.lines)
+		}
+		if drive.Tracks() != tracks {
+			t.Fatalf("action %d commands %v, want %v", test.action, drive.Tracks(), tracks)
+		}
+	}
+}
+
+func TestL298NInvert(t *testing.T) {
+	drive, gpio, profile := simL298N(t)
+	defer drive.Close()
+	drive.Left.Invert = true
+	if err := drive.SetTracks(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if gpio.Value(profile.Left.A) != 0 || gpio.Value(profile.Left.B) != 1 {
+		t.Fatal("the inverted track is not reversed")
+	}
+}
+
+func TestL298NClose(t *testing.T) {
+	drive, gpio, profile := simL298N(t)
+	drive.SetTracks(1, -1)
+	if err := drive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, offset := range []int{profile.Left.A, profile.Left.B, profile.Right.A, profile.Right.B} {
+		if gpio.Value(offset) != 0 {
+			t.Fatalf("line %d is still high", offset)
+		}
+		if _, err := gpio.Output(offset, 0); err != nil {
+			t.Fatalf("line %d was not released: %v", offset, err)
+		}
+	}
+}
+
+func TestBalance(t *testing.T) {
+	tests := []struct {
+		left, right, shift  float64
+		wantLeft, wantRight float64
+	}{
+		{1, 1, .1, .9, 1},
+		{1, 1, -.1, 1, .9},
+		{.9, 1, -.1, 1, 1},
+		{.9, 1, -.3, 1, .8},
+	}
+	for _, test := range tests {
+		left, right := Balance(test.left, test.right, test.shift)
+		if !near(left, test.wantLeft) || !near(right, test.wantRight) {
+			t.Fatalf("Balance(%v, %v, %v) = %v, %v, want %v, %v", test.left, test.right, test.shift,
+				left, right, test.wantLeft, test.wantRight)
+		}
+	}
+}
+
+func TestFakeDrive(t *testing.T) {
+	drive := NewFakeDrive()
+	drive.SetTracks(2, -.5)
+	drive.Stop()
+	want := []Tracks{{Left: 1, Right: -.5}, {}}
+	if len(drive.History) != len(want) {
+		t.Fatal(drive.History)
+	}
+	for i := range want {
+		if drive.History[i] != want[i] {
+			t.Fatalf("command %d is %v, want %v", i, drive.History[i], want[i])
+		}
+	}
+	if err := drive.Close(); err != nil || !drive.Closed || drive.Current() != (Tracks{}) {
+		t.Fatal("the drive was not closed")
+	}
+}
+
+// near is true when a and b are within a small tolerance
+func near(a, b float64) bool {
+	return a-b < 1e-9 && b-a < 1e-9
+}
@@ -0,0 +1,218 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// quadrature maps the previous and current channel states of a quadrature encoder to a tick,
+// channel A leading channel B counts up
+var quadrature = [16]int64{0, -1, 1, 0, 1, 0, 0, -1, -1, 0, 0, 1, 0, 1, -1, 0}
+
+// Encoder counts the ticks of a quadrature or single channel wheel encoder
+type Encoder struct {
+	// A is the line of channel A
+	A int
+	// B is the line of channel B, negative for a single channel encoder
+	B int
+	// Direction is the commanded direction of the track, it signs the ticks of a single channel encoder
+	Direction func() float64
+
+	mutex sync.Mutex
+	state int
+	sign  int64
+	ticks int64
+}
+
+// NewQuadratureEncoder creates a new encoder with channels a and b
+func NewQuadratureEncoder(a, b int) *Encoder {
+	return &Encoder{
+		A: a,
+		B: b,
+	}
+}
+
+// NewSingleEncoder creates a new single channel encoder, direction signs the ticks
+func NewSingleEncoder(a int, direction func() float64) *Encoder {
+	return &Encoder{
+		A:         a,
+		B:         -1,
+		Direction: direction,
+		sign:      1,
+	}
+}
+
+// Open requests the lines of the channels from gpio and reads their state, so that the first edge
+// counts from the actual state of the channels
+func (e *Encoder) Open(gpio GPIO) ([]InputLine, error) {
+	var lines []InputLine
+	for _, offset := range []int{e.A, e.B} {
+		if offset < 0 {
+			continue
+		}
+		line, err := gpio.Input(offset, e.Edge)
+		if err != nil {
+			return lines, err
+		}
+		lines = append(lines, line)
+		value, err := line.Value()
+		if err != nil {
+			return lines, err
+		}
+		e.mutex.Lock()
+		switch offset {
+		case e.A:
+			e.state = e.state&1 | value<<1
+		case e.B:
+			e.state = e.state&2 | value
+		}
+		e.mutex.Unlock()
+	}
+	return lines, nil
+}
+
+// Edge counts an edge on one of the channels
+func (e *Encoder) Edge(edge Edge) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	bit := 0
+	if edge.Rising {
+		bit = 1
+	}
+	if e.B < 0 {
+		if edge.Offset != e.A {
+			return
+		}
+		if direction := e.Direction(); direction > 0 {
+			e.sign = 1
+		} else if direction < 0 {
+			e.sign = -1
+		}
+		e.ticks += e.sign
+		return
+	}
+	state := e.state
+	switch edge.Offset {
+	case e.A:
+		state = state&1 | bit<<1
+	case e.B:
+		state = state&2 | bit
+	default:
+		return
+	}
+	e.ticks += quadrature[e.state<<2|state]
+	e.state = state
+}
+
+// Ticks returns the signed tick count
+func (e *Encoder) Ticks() int64 {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.ticks
+}
+
+// Pose is a dead reckoned position in meters and heading in radians
+type Pose struct {
+	X       float64
+	Y       float64
+	Heading float64
+}
+
+// Move integrates the distance traveled by the left and right tracks into the pose,
+// width is the distance between the tracks
+func (p Pose) Move(left, right, width float64) Pose {
+	distance := (left + right) / 2
+	turn := (right - left) / width
+	heading := p.Heading + turn/2
+	return Pose{
+		X:       p.X + distance*math.Cos(heading),
+		Y:       p.Y + distance*math.Sin(heading),
+		Heading: math.Remainder(p.Heading+turn, 2*math.Pi),
+	}
+}
+
+// Odometry dead reckons the pose of the robot from the track encoders
+type Odometry struct {
+	Left  *Encoder
+	Right *Encoder
+	// TicksPerMeter is the number of encoder ticks per meter of track travel
+	TicksPerMeter float64
+	// Width is the distance in meters between the tracks
+	Width float64
+
+	mutex    sync.Mutex
+	pose     Pose
+	velocity Tracks
+	ticks    [2]int64
+	last     time.Time
+}
+
+// NewOdometry creates a new odometry from the left and right encoders
+func NewOdometry(left, right *Encoder, ticksPerMeter, width float64) *Odometry {
+	return &Odometry{
+		Left:          left,
+		Right:         right,
+		TicksPerMeter: ticksPerMeter,
+		Width:         width,
+	}
+}
+
+// Update integrates the ticks since the last update into the pose and velocity
+func (o *Odometry) Update(now time.Time) {
+	left, right := o.Left.Ticks(), o.Right.Ticks()
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	l := float64(left-o.ticks[0]) / o.TicksPerMeter
+	r := float64(right-o.ticks[1]) / o.TicksPerMeter
+	o.ticks = [2]int64{left, right}
+	o.pose = o.pose.Move(l, r, o.Width)
+	if !o.last.IsZero() {
+		if dt := now.Sub(o.last).Seconds(); dt > 0 {
+			o.velocity = Tracks{
+				Left:  l / dt,
+				Right: r / dt,
+			}
+		}
+	}
+	o.last = now
+}
+
+// Run updates the odometry every period until done is closed
+func (o *Odometry) Run(period time.Duration, done <-chan struct{}) {
+	t := time.NewTicker(period)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-t.C:
+			o.Update(now)
+		}
+	}
+}
+
+// Pose returns the dead reckoned pose
+func (o *Odometry) Pose() Pose {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.pose
+}
+
+// Velocity returns the speed of the left and right tracks in meters per second
+func (o *Odometry) Velocity() Tracks {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.velocity
+}
+
+// Reset sets the pose back to the origin
+func (o *Odometry) Reset() {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.pose = Pose{}
+}
@@ -0,0 +1,182 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// testA and testB are the channels of the test encoders
+const (
+	testA = 5
+	testB = 6
+)
+
+func TestQuadratureEncoder(t *testing.T) {
+	// a is channel A rising, A is channel A falling and so on
+	edges := map[rune]Edge{
+		'a': {Offset: testA, Rising: true},
+		'A': {Offset: testA},
+		'b': {Offset: testB, Rising: true},
+		'B': {Offset: testB},
+		'x': {Offset: 7, Rising: true},
+	}
+	tests := []struct {
+		name  string
+		edges string
+		ticks int64
+	}{
+		{"forward", "abAB", 4},
+		{"forward twice", "abABabAB", 8},
+		{"reverse", "baBA", -4},
+		{"forward then back", "abABbaBA", 0},
+		{"jitter", "aAaAaA", 0},
+		{"forward part", "ab", 2},
+		{"other line", "axbx", 2},
+	}
+	for _, test := range tests {
+		encoder := NewQuadratureEncoder(testA, testB)
+		for _, edge := range test.edges {
+			encoder.Edge(edges[edge])
+		}
+		if ticks := encoder.Ticks(); ticks != test.ticks {
+			t.Fatalf("%s: %d ticks, want %d", test.name, ticks, test.ticks)
+		}
+	}
+}
+
+func TestSingleEncoder(t *testing.T) {
+	tests := []struct {
+		name       string
+		directions []float64
+		ticks      int64
+	}{
+		{"forward", []float64{1, 1, 1, 1}, 4},
+		{"reverse", []float64{-1, -.5, -1, -1}, -4},
+		{"coasting keeps the last direction", []float64{-1, 0, 0, 0}, -4},
+		{"coasting from rest is forward", []float64{0, 0}, 2},
+		{"reversing", []float64{1, 1, -1, -1, -1}, -1},
+	}
+	for _, test := range tests {
+		var direction float64
+		encoder := NewSingleEncoder(testA, func() float64 {
+			return direction
+		})
+		for i, d := range test.directions {
+			direction = d
+			// both edges of channel A are ticks
+			encoder.Edge(Edge{Offset: testA, Rising: i%2 == 0})
+			// other lines are ignored
+			encoder.Edge(Edge{Offset: testB, Rising: true})
+		}
+		if ticks := encoder.Ticks(); ticks != test.ticks {
+			t.Fatalf("%s: %d ticks, want %d", test.name, ticks, test.ticks)
+		}
+	}
+}
+
+func TestEncoderSimGPIO(t *testing.T) {
+	gpio := NewSimGPIO()
+	encoder := NewQuadratureEncoder(testA, testB)
+	for _, offset := range []int{testA, testB} {
+		if _, err := gpio.Input(offset, encoder.Edge); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		gpio.Inject(testA, 1)
+		gpio.Inject(testB, 1)
+		gpio.Inject(testA, 0)
+		gpio.Inject(testB, 0)
+	}
+	if ticks := encoder.Ticks(); ticks != 40 {
+		t.Fatalf("%d ticks, want 40", ticks)
+	}
+}
+
+func TestEncoderOpen(t *testing.T) {
+	gpio := NewSimGPIO()
+	// the wheel rests with both channels high
+	gpio.Inject(testA, 1)
+	gpio.Inject(testB, 1)
+	encoder := NewQuadratureEncoder(testA, testB)
+	lines, err := encoder.Open(gpio)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("%d lines were opened", len(lines))
+	}
+	gpio.Inject(testA, 0)
+	gpio.Inject(testB, 0)
+	gpio.Inject(testA, 1)
+	gpio.Inject(testB, 1)
+	if ticks := encoder.Ticks(); ticks != 4 {
+		t.Fatalf("%d ticks, want 4", ticks)
+	}
+	for _, line := range lines {
+		line.Close()
+	}
+}
+
+func TestPoseMove(t *testing.T) {
+	const width, steps = .2, 100
+	radius := 1.0
+	tests := []struct {
+		name        string
+		left, right float64
+		want        Pose
+	}{
+		{"straight", 1, 1, Pose{X: 1}},
+		{"reverse", -.5, -.5, Pose{X: -.5}},
+		{"spin left", -math.Pi * width / 4, math.Pi * width / 4, Pose{Heading: math.Pi / 2}},
+		{"spin right", math.Pi * width / 4, -math.Pi * width / 4, Pose{Heading: -math.Pi / 2}},
+		// a quarter circle to the left around a point radius to the left of the robot
+		{"arc left", (radius - width/2) * math.Pi / 2, (radius + width/2) * math.Pi / 2,
+			Pose{X: radius, Y: radius, Heading: math.Pi / 2}},
+		{"arc right", (radius + width/2) * math.Pi / 2, (radius - width/2) * math.Pi / 2,
+			Pose{X: radius, Y: -radius, Heading: -math.Pi / 2}},
+	}
+	for _, test := range tests {
+		var pose Pose
+		for i := 0; i < steps; i++ {
+			pose = pose.Move(test.left/steps, test.right/steps, width)
+		}
+		if math.Abs(pose.X-test.want.X) > 1e-3 || math.Abs(pose.Y-test.want.Y) > 1e-3 ||
+			math.Abs(pose.Heading-test.want.Heading) > 1e-9 {
+			t.Fatalf("%s: pose is %+v, want %+v", test.name, pose, test.want)
+		}
+	}
+}
+
+func TestOdometry(t *testing.T) {
+	left, right := NewQuadratureEncoder(testA, testB), NewQuadratureEncoder(testA, testB)
+	odometry := NewOdometry(left, right, 100, .2)
+	start := time.Now()
+	odometry.Update(start)
+	// 12 ticks of 1 cm forward on both tracks in half a second
+	for i := 0; i < 3; i++ {
+		for _, encoder := range []*Encoder{left, right} {
+			for _, edge := range []Edge{{Offset: testA, Rising: true}, {Offset: testB, Rising: true},
+				{Offset: testA}, {Offset: testB}} {
+				encoder.Edge(edge)
+			}
+		}
+	}
+	odometry.Update(start.Add(time.Second / 2))
+	pose, velocity := odometry.Pose(), odometry.Velocity()
+	if math.Abs(pose.X-.12) > 1e-9 || pose.Y != 0 || pose.Heading != 0 {
+		t.Fatalf("pose is %+v", pose)
+	}
+	if math.Abs(velocity.Left-.24) > 1e-9 || math.Abs(velocity.Right-.24) > 1e-9 {
+		t.Fatalf("velocity is %+v", velocity)
+	}
+	odometry.Reset()
+	if odometry.Pose() != (Pose{}) {
+		t.Fatal("not reset")
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/zergon321/reisen"
+)
+
+// FileCameraOptions are the replay options of a file camera
+type FileCameraOptions struct {
+	// Pace sends frames at the rate they were recorded, otherwise frames are sent as fast as they are read
+	Pace bool
+	// Loop starts over at the offset when the end of the file is reached
+	Loop bool
+	// Offset is where in the file replay starts
+	Offset time.Duration
+}
+
+// FileCamera is a camera that replays a video file
+type FileCamera struct {
+	cameraStream
+	// Path is the video file
+	Path    string
+	Options FileCameraOptions
+}
+
+// NewFileCamera creates a new camera that replays a mp4, mkv or h264 file
+func NewFileCamera(name, path string, options FileCameraOptions) *FileCamera {
+	return &FileCamera{
+		cameraStream: newCameraStream(CameraInfo{
+			Name:   name,
+			Device: path,
+		}, 0),
+		Path:    path,
+		Options: options,
+	}
+}
+
+// Start opens the file and replays it until the end, ctx is canceled or Stop is called
+func (fc *FileCamera) Start(ctx context.Context) error {
+	ctx, err := fc.begin(ctx)
+	if err != nil {
+		return err
+	}
+	media, stream, err := fc.open()
+	if err != nil {
+		fc.end(err)
+		return err
+	}
+	go func() {
+		err := fc.replay(ctx, media, stream)
+		stream.Close()
+		media.CloseDecode()
+		media.Close()
+		fc.end(err)
+	}()
+	return nil
+}
+
+// open opens the file and its first video stream and seeks to the offset
+func (fc *FileCamera) open() (*reisen.Media, *reisen.VideoStream, error) {
+	media, err := reisen.NewMedia(fc.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = media.OpenDecode()
+	if err != nil {
+		media.Close()
+		return nil, nil, err
+	}
+	streams := media.VideoStreams()
+	if len(streams) == 0 {
+		media.CloseDecode()
+		media.Close()
+		return nil, nil, fmt.Errorf("%s has no video stream", fc.Path)
+	}
+	stream := streams[0]
+	err = stream.Open()
+	if err != nil {
+		media.CloseDecode()
+		media.Close()
+		return nil, nil, err
+	}
+	if fc.Options.Offset > 0 {
+		err = stream.Rewind(fc.Options.Offset)
+		if err != nil {
+			stream.Close()
+			media.CloseDecode()
+			media.Close()
+			return nil, nil, err
+		}
+	}
+	info := fc.Info()
+	info.Format, info.Width, info.Height = "file", stream.Width(), stream.Height()
+	fc.setInfo(info)
+	return media, stream, nil
+}
+
+// replay decodes the video stream into frames
+func (fc *FileCamera) replay(ctx context.Context, media *reisen.Media, stream *reisen.VideoStream) error {
+	// the frame interval is used when the file has no usable timestamps, like a raw h264 stream
+	interval := time.Second / 30
+	if num, den := stream.FrameRate(); num > 0 && den > 0 {
+		interval = time.Duration(float64(time.Second) * float64(den) / float64(num))
+	}
+	var start time.Time
+	var first, last time.Duration
+	for ctx.Err() == nil {
+		pkt, gotPacket, err := media.ReadPacket()
+		if err != nil {
+			return err
+		}
+
+		if !gotPacket {
+			if !fc.Options.Loop {
+				return nil
+			}
+			err = stream.Rewind(fc.Options.Offset)
+			if err != nil {
+				return err
+			}
+			start = time.Time{}
+			continue
+		}
+
+		if pkt.StreamIndex() != stream.Index() {
+			continue
+		}
+
+		videoFrame, gotFrame, err := stream.ReadVideoFrame()
+		if err != nil {
+			return err
+		}
+
+		if !gotFrame || videoFrame == nil {
+			continue
+		}
+
+		frame := Frame{
+			Frame: videoFrame.Image(),
+		}
+		if !fc.Options.Pace {
+			// every frame is sent as fast as it is read, so the rate does not apply
+			fc.capture(time.Now())
+			select {
+			case fc.frames <- fc.stamp(frame):
+			case <-ctx.Done():
+			}
+			continue
+		}
+
+		offset, err := videoFrame.PresentationOffset()
+		if err != nil || (!start.IsZero() && offset <= last) {
+			offset = last + interval
+		}
+		if start.IsZero() {
+			start, first = time.Now(), offset
+		}
+		last = offset
+		wait := time.NewTimer(time.Until(start.Add(offset - first)))
+		select {
+		case <-wait.C:
+		case <-ctx.Done():
+			wait.Stop()
+			return nil
+		}
+		if fc.capture(time.Now()) {
+			fc.send(frame)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// clipBar is the width of the bar that numbers the frames of a test clip
+const clipBar = 8
+
+// writeClip writes a y4m clip of frames at fps and returns its path, frame i is black
+// with a white bar at column i so that the frame can be told from its image
+func writeClip(t *testing.T, frames, fps int) string {
+	t.Helper()
+	width, height := clipBar*frames, 16
+	var clip bytes.Buffer
+	fmt.Fprintf(&clip, "YUV4MPEG2 W%d H%d F%d:1 Ip A1:1 C420jpeg\n", width, height, fps)
+	for i := 0; i < frames; i++ {
+		clip.WriteString("FRAME\n")
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				if x/clipBar == i {
+					clip.WriteByte(235)
+				} else {
+					clip.WriteByte(16)
+				}
+			}
+		}
+		clip.Write(bytes.Repeat([]byte{128}, width*height/2))
+	}
+	path := filepath.Join(t.TempDir(), "clip.y4m")
+	if err := os.WriteFile(path, clip.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// clipFrame returns the number of a frame of a test clip
+func clipFrame(img image.Image) int {
+	b := img.Bounds()
+	frame, brightest := -1, uint32(0)
+	for i := 0; i < b.Dx()/clipBar; i++ {
+		r, _, _, _ := img.At(b.Min.X+clipBar*i+clipBar/2, b.Min.Y+b.Dy()/2).RGBA()
+		if r > brightest {
+			frame, brightest = i, r
+		}
+	}
+	return frame
+}
+
+// readFrames reads n frames from a camera and returns their numbers and when they arrived
+func readFrames(t *testing.T, camera Camera, n int) ([]int, []time.Time) {
+	t.Helper()
+	var frames []int
+	var times []time.Time
+	timeout := time.After(10 * time.Second)
+	for len(frames) < n {
+		select {
+		case frame, ok := <-camera.Frames():
+			if !ok {
+				t.Fatalf("the camera ended after frames %v: %v", frames, camera.Stop())
+			}
+			frames = append(frames, clipFrame(frame.Frame))
+			times = append(times, time.Now())
+		case <-timeout:
+			t.Fatalf("only frames %v arrived", frames)
+		}
+	}
+	return frames, times
+}
+
+func TestFileCameraPace(t *testing.T) {
+	// 8 frames at 10 fps take 700 ms when paced
+	path := writeClip(t, 8, 10)
+	for _, pace := range []bool{true, false} {
+		camera := NewFileCamera("file", path, FileCameraOptions{Pace: pace})
+		if err := camera.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if info := camera.Info(); info.Width != 8*clipBar || info.Height != 16 {
+			t.Fatalf("the clip is %dx%d", info.Width, info.Height)
+		}
+		frames, times := readFrames(t, camera, 8)
+		for i, frame := range frames {
+			if frame != i {
+				t.Fatalf("pace %v: the frames are %v", pace, frames)
+			}
+		}
+		elapsed := times[7].Sub(times[0])
+		if pace && elapsed < 600*time.Millisecond {
+			t.Fatalf("the paced frames took %v", elapsed)
+		}
+		if !pace && elapsed > 350*time.Millisecond {
+			t.Fatalf("the frames that are not paced took %v", elapsed)
+		}
+		// the replay ends at the end of the clip
+		select {
+		case _, ok := <-camera.Frames():
+			if ok {
+				t.Fatal("a frame arrived after the end of the clip")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("the replay did not end")
+		}
+		if err := camera.Stop(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFileCameraLoop(t *testing.T) {
+	path := writeClip(t, 8, 10)
+	// the replay starts at frame 3 and loops back to it
+	camera := NewFileCamera("file", path, FileCameraOptions{Loop: true, Offset: 300 * time.Millisecond})
+	if err := camera.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	frames, _ := readFrames(t, camera, 12)
+	want := []int{3, 4, 5, 6, 7, 3, 4, 5, 6, 7, 3, 4}
+	for i := range want {
+		if frames[i] != want[i] {
+			t.Fatalf("the frames are %v, want %v", frames, want)
+		}
+	}
+	if err := camera.Stop(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,255 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"math"
+)
+
+// ErrSingular is returned when a linear system has no unique solution
+var ErrSingular = errors.New("matrix is singular")
+
+// vec2 is a 2 vector
+type vec2 [2]float64
+
+// sub returns a - b
+func (a vec2) sub(b vec2) vec2 {
+	return vec2{a[0] - b[0], a[1] - b[1]}
+}
+
+// add returns a + b
+func (a vec2) add(b vec2) vec2 {
+	return vec2{a[0] + b[0], a[1] + b[1]}
+}
+
+// scale returns a * s
+func (a vec2) scale(s float64) vec2 {
+	return vec2{a[0] * s, a[1] * s}
+}
+
+// norm returns the length of a
+func (a vec2) norm() float64 {
+	return math.Hypot(a[0], a[1])
+}
+
+// vec3 is a 3 vector
+type vec3 [3]float64
+
+// sub returns a - b
+func (a vec3) sub(b vec3) vec3 {
+	return vec3{a[0] - b[0], a[1] - b[1], a[2] - b[2]}
+}
+
+// scale returns a * s
+func (a vec3) scale(s float64) vec3 {
+	return vec3{a[0] * s, a[1] * s, a[2] * s}
+}
+
+// dot returns the dot product of a and b
+func (a vec3) dot(b vec3) float64 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
+
+// cross returns the cross product of a and b
+func (a vec3) cross(b vec3) vec3 {
+	return vec3{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+}
+
+// norm returns the length of a
+func (a vec3) norm() float64 {
+	return math.Sqrt(a.dot(a))
+}
+
+// unit returns a scaled to unit length
+func (a vec3) unit() vec3 {
+	return a.scale(1 / a.norm())
+}
+
+// mat3 is a row major 3x3 matrix
+type mat3 [9]float64
+
+// mulVec returns m * v
+func (m mat3) mulVec(v vec3) vec3 {
+	return vec3{
+		m[0]*v[0] + m[1]*v[1] + m[2]*v[2],
+		m[3]*v[0] + m[4]*v[1] + m[5]*v[2],
+		m[6]*v[0] + m[7]*v[1] + m[8]*v[2],
+	}
+}
+
+// transpose returns the transpose of m
+func (m mat3) transpose() mat3 {
+	return mat3{m[0], m[3], m[6], m[1], m[4], m[7], m[2], m[5], m[8]}
+}
+
+// add returns a + b
+func (a vec3) add(b vec3) vec3 {
+	return vec3{a[0] + b[0], a[1] + b[1], a[2] + b[2]}
+}
+
+// mul returns m * n
+func (m mat3) mul(n mat3) mat3 {
+	var p mat3
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				p[3*i+j] += m[3*i+k] * n[3*k+j]
+			}
+		}
+	}
+	return p
+}
+
+// identity3 is the 3x3 identity matrix
+var identity3 = mat3{1, 0, 0, 0, 1, 0, 0, 0, 1}
+
+// rodrigues returns the rotation matrix of a rotation vector, the axis scaled by the angle in radians
+func rodrigues(r vec3) mat3 {
+	angle := r.norm()
+	if angle < 1e-12 {
+		// first order for tiny angles
+		return mat3{1, -r[2], r[1], r[2], 1, -r[0], -r[1], r[0], 1}
+	}
+	k := r.scale(1 / angle)
+	c, s := math.Cos(angle), math.Sin(angle)
+	v := 1 - c
+	return mat3{
+		c + k[0]*k[0]*v, k[0]*k[1]*v - k[2]*s, k[0]*k[2]*v + k[1]*s,
+		k[1]*k[0]*v + k[2]*s, c + k[1]*k[1]*v, k[1]*k[2]*v - k[0]*s,
+		k[2]*k[0]*v - k[1]*s, k[2]*k[1]*v + k[0]*s, c + k[2]*k[2]*v,
+	}
+}
+
+// rotationVector returns the rotation vector of a rotation matrix
+func rotationVector(m mat3) vec3 {
+	axis := vec3{m[7] - m[5], m[2] - m[6], m[3] - m[1]}
+	sin := axis.norm() / 2
+	cos := (m[0] + m[4] + m[8] - 1) / 2
+	angle := math.Atan2(sin, cos)
+	if sin > 1e-6 {
+		return axis.scale(angle / (2 * sin))
+	}
+	if cos > 0 {
+		return axis.scale(.5)
+	}
+	// a half turn, the axis is the column of m + I with the largest norm
+	best := vec3{}
+	for j := 0; j < 3; j++ {
+		column := vec3{m[j], m[3+j], m[6+j]}
+		column[j]++
+		if column.norm() > best.norm() {
+			best = column
+		}
+	}
+	return best.unit().scale(math.Pi)
+}
+
+// orthonormalize returns the rotation nearest a matrix whose columns are close to orthonormal
+func orthonormalize(m mat3) mat3 {
+	x := vec3{m[0], m[3], m[6]}.unit()
+	y := vec3{m[1], m[4], m[7]}
+	y = y.sub(x.scale(x.dot(y))).unit()
+	z := x.cross(y)
+	return mat3{x[0], y[0], z[0], x[1], y[1], z[1], x[2], y[2], z[2]}
+}
+
+// solve solves the n by n row major system a x = b with gaussian elimination, a and b are overwritten
+func solve(a, b []float64) ([]float64, error) {
+	n := len(b)
+	for c := 0; c < n; c++ {
+		pivot := c
+		for r := c + 1; r < n; r++ {
+			if math.Abs(a[r*n+c]) > math.Abs(a[pivot*n+c]) {
+				pivot = r
+			}
+		}
+		if math.Abs(a[pivot*n+c]) < 1e-300 {
+			return nil, ErrSingular
+		}
+		if pivot != c {
+			for k := 0; k < n; k++ {
+				a[c*n+k], a[pivot*n+k] = a[pivot*n+k], a[c*n+k]
+			}
+			b[c], b[pivot] = b[pivot], b[c]
+		}
+		for r := c + 1; r < n; r++ {
+			f := a[r*n+c] / a[c*n+c]
+			if f == 0 {
+				continue
+			}
+			for k := c; k < n; k++ {
+				a[r*n+k] -= f * a[c*n+k]
+			}
+			b[r] -= f * b[c]
+		}
+	}
+	x := make([]float64, n)
+	for r := n - 1; r >= 0; r-- {
+		sum := b[r]
+		for k := r + 1; k < n; k++ {
+			sum -= a[r*n+k] * x[k]
+		}
+		x[r] = sum / a[r*n+r]
+	}
+	return x, nil
+}
+
+// nullVector returns the unit eigenvector of the smallest eigenvalue of the n by n row major
+// symmetric matrix a using jacobi rotations, a is overwritten
+func nullVector(a []float64, n int) []float64 {
+	v := make([]float64, n*n)
+	for i := 0; i < n; i++ {
+		v[i*n+i] = 1
+	}
+	for sweep := 0; sweep < 100; sweep++ {
+		off := 0.0
+		for p := 0; p < n; p++ {
+			for q := p + 1; q < n; q++ {
+				off += a[p*n+q] * a[p*n+q]
+			}
+		}
+		if off < 1e-30 {
+			break
+		}
+		for p := 0; p < n; p++ {
+			for q := p + 1; q < n; q++ {
+				if a[p*n+q] == 0 {
+					continue
+				}
+				theta := (a[q*n+q] - a[p*n+p]) / (2 * a[p*n+q])
+				t := 1 / (math.Abs(theta) + math.Sqrt(theta*theta+1))
+				if theta < 0 {
+					t = -t
+				}
+				c := 1 / math.Sqrt(t*t+1)
+				s := t * c
+				for k := 0; k < n; k++ {
+					akp, akq := a[k*n+p], a[k*n+q]
+					a[k*n+p], a[k*n+q] = c*akp-s*akq, s*akp+c*akq
+				}
+				for k := 0; k < n; k++ {
+					apk, aqk := a[p*n+k], a[q*n+k]
+					a[p*n+k], a[q*n+k] = c*apk-s*aqk, s*apk+c*aqk
+				}
+				for k := 0; k < n; k++ {
+					vkp, vkq := v[k*n+p], v[k*n+q]
+					v[k*n+p], v[k*n+q] = c*vkp-s*vkq, s*vkp+c*vkq
+				}
+			}
+		}
+	}
+	smallest := 0
+	for i := 1; i < n; i++ {
+		if a[i*n+i] < a[smallest*n+smallest] {
+			smallest = i
+		}
+	}
+	x := make([]float64, n)
+	for k := 0; k < n; k++ {
+		x[k] = v[k*n+smallest]
+	}
+	return x
+}
@@ -14,6 +14,7 @@ require (
 	github.com/veandco/go-sdl2 v0.4.35
 	github.com/warthog618/gpiod v0.8.0
 	github.com/zergon321/reisen v0.1.9
+	google.golang.org/protobuf v1.24.0
 )
 
 require (
@@ -32,5 +33,4 @@ require (
 	golang.org/x/sys v0.11.0 // indirect
 	golang.org/x/text v0.12.0 // indirect
 	gonum.org/v1/plot v0.14.0 // indirect
-	google.golang.org/protobuf v1.24.0 // indirect
 )
@@ -0,0 +1,272 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/warthog618/gpiod"
+)
+
+// GPIO is a source of gpio lines
+type GPIO interface {
+	// Output requests a line as an output with an initial value
+	Output(offset, value int) (Line, error)
+	// Input requests a line as an input, handler is called on both edges
+	Input(offset int, handler func(Edge)) (InputLine, error)
+	// Close releases the backend
+	Close() error
+}
+
+// InputLine is a digital input line
+type InputLine interface {
+	Value() (int, error)
+	Close() error
+}
+
+// Edge is an edge event on an input line
+type Edge struct {
+	Offset int
+	Rising bool
+	// Time is the monotonic time of the edge
+	Time time.Duration
+}
+
+// ChipGPIO is a gpio backend using a gpiod chip
+type ChipGPIO struct {
+	Chip string
+}
+
+// NewChipGPIO creates a new gpio backend for a chip such as gpiochip0
+func NewChipGPIO(chip string) *ChipGPIO {
+	return &ChipGPIO{
+		Chip: chip,
+	}
+}
+
+// Output requests a line as an output with an initial value
+func (c *ChipGPIO) Output(offset, value int) (Line, error) {
+	line, err := gpiod.RequestLine(c.Chip, offset, gpiod.AsOutput(value))
+	if err != nil {
+		return nil, err
+	}
+	return line, nil
+}
+
+// Input requests a line as an input, handler is called on both edges
+func (c *ChipGPIO) Input(offset int, handler func(Edge)) (InputLine, error) {
+	line, err := gpiod.RequestLine(c.Chip, offset, gpiod.AsInput, gpiod.WithBothEdges,
+		gpiod.WithEventHandler(func(event gpiod.LineEvent) {
+			handler(Edge{
+				Offset: event.Offset,
+				Rising: event.Type == gpiod.LineEventRisingEdge,
+				Time:   event.Timestamp,
+			})
+		}))
+	if err != nil {
+		return nil, err
+	}
+	return line, nil
+}
+
+// Close releases the backend
+func (c *ChipGPIO) Close() error {
+	return nil
+}
+
+// Transition is a recorded change in the value of a simulated line
+type Transition struct {
+	Offset int
+	Value  int
+	// Time is the monotonic time since the backend was created
+	Time time.Duration
+}
+
+// SimGPIO is a simulated gpio backend that records line transitions
+type SimGPIO struct {
+	mutex       sync.Mutex
+	start       time.Time
+	values      map[int]int
+	requested   map[int]bool
+	handlers    map[int]func(Edge)
+	transitions []Transition
+}
+
+// NewSimGPIO creates a new simulated gpio backend
+func NewSimGPIO() *SimGPIO {
+	return &SimGPIO{
+		start:     time.Now(),
+		values:    make(map[int]int),
+		requested: make(map[int]bool),
+		handlers:  make(map[int]func(Edge)),
+	}
+}
+
+// Now returns the monotonic time since the backend was created
+func (s *SimGPIO) Now() time.Duration {
+	return time.Since(s.start)
+}
+
+// Output requests a line as an output with an initial value
+func (s *SimGPIO) Output(offset, value int) (Line, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.requested[offset] {
+		return nil, fmt.Errorf("line %d is busy", offset)
+	}
+	s.requested[offset] = true
+	s.set(offset, value)
+	return &SimLine{
+		GPIO:   s,
+		Offset: offset,
+	}, nil
+}
+
+// Input requests a line as an input, handler is called on both edges injected with Inject
+func (s *SimGPIO) Input(offset int, handler func(Edge)) (InputLine, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.requested[offset] {
+		return nil, fmt.Errorf("line %d is busy", offset)
+	}
+	s.requested[offset] = true
+	s.handlers[offset] = handler
+	if _, ok := s.values[offset]; !ok {
+		s.set(offset, 0)
+	}
+	return &SimLine{
+		GPIO:   s,
+		Offset: offset,
+	}, nil
+}
+
+// Inject sets the value of an input line and calls its handler if the value changed
+func (s *SimGPIO) Inject(offset, value int) {
+	s.mutex.Lock()
+	last := s.values[offset]
+	s.set(offset, value)
+	handler := s.handlers[offset]
+	value, now := s.values[offset], s.Now()
+	s.mutex.Unlock()
+	if handler != nil && last != value {
+		handler(Edge{
+			Offset: offset,
+			Rising: value == 1,
+			Time:   now,
+		})
+	}
+}
+
+// set records the value of a line if it changed, the mutex must be held
+func (s *SimGPIO) set(offset, value int) {
+	if value != 0 {
+		value = 1
+	}
+	if last, ok := s.values[offset]; ok && last == value {
+		return
+	}
+	s.values[offset] = value
+	s.transitions = append(s.transitions, Transition{
+		Offset: offset,
+		Value:  value,
+		Time:   s.Now(),
+	})
+}
+
+// Value returns the current value of a line
+func (s *SimGPIO) Value(offset int) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.values[offset]
+}
+
+// Transitions returns the recorded transitions of a line
+func (s *SimGPIO) Transitions(offset int) []Transition {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var transitions []Transition
+	for _, t := range s.transitions {
+		if t.Offset == offset {
+			transitions = append(transitions, t)
+		}
+	}
+	return transitions
+}
+
+// Duty returns the fraction of the time between from and to that a line was high
+func (s *SimGPIO) Duty(offset int, from, to time.Duration) float64 {
+	if to <= from {
+		return 0
+	}
+	transitions := s.Transitions(offset)
+	high, value, last := time.Duration(0), 0, from
+	for _, t := range transitions {
+		if t.Time <= from {
+			value = t.Value
+			continue
+		}
+		if t.Time >= to {
+			break
+		}
+		if value == 1 {
+			high += t.Time - last
+		}
+		value, last = t.Value, t.Time
+	}
+	if value == 1 {
+		high += to - last
+	}
+	return float64(high) / float64(to-from)
+}
+
+// Reset clears the recorded transitions
+func (s *SimGPIO) Reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.transitions = s.transitions[:0]
+}
+
+// Close releases the backend
+func (s *SimGPIO) Close() error {
+	return nil
+}
+
+// SimLine is a simulated line
+type SimLine struct {
+	GPIO   *SimGPIO
+	Offset int
+	closed bool
+}
+
+// SetValue sets the value of the line
+func (l *SimLine) SetValue(value int) error {
+	l.GPIO.mutex.Lock()
+	defer l.GPIO.mutex.Unlock()
+	if l.closed {
+		return fmt.Errorf("line %d is closed", l.Offset)
+	}
+	l.GPIO.set(l.Offset, value)
+	return nil
+}
+
+// Value returns the value of the line
+func (l *SimLine) Value() (int, error) {
+	return l.GPIO.Value(l.Offset), nil
+}
+
+// Close releases the line
+func (l *SimLine) Close() error {
+	l.GPIO.mutex.Lock()
+	defer l.GPIO.mutex.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	delete(l.GPIO.requested, l.Offset)
+	delete(l.GPIO.handlers, l.Offset)
+	return nil
+}
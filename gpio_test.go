@@ -0,0 +1,106 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSimGPIOTransitions(t *testing.T) {
+	gpio := NewSimGPIO()
+	line, err := gpio.Output(20, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gpio.Output(20, 0); err == nil {
+		t.Fatal("a busy line was requested twice")
+	}
+	for _, value := range []int{1, 1, 0, 5} {
+		if err := line.SetValue(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	transitions := gpio.Transitions(20)
+	// repeated values are not transitions and any value other than zero is high
+	values := []int{0, 1, 0, 1}
+	if len(transitions) != len(values) {
+		t.Fatalf("got %d transitions, want %d", len(transitions), len(values))
+	}
+	for i, transition := range transitions {
+		if transition.Value != values[i] {
+			t.Fatalf("transition %d is %d, want %d", i, transition.Value, values[i])
+		}
+		if i > 0 && transition.Time < transitions[i-1].Time {
+			t.Fatalf("transition %d is before transition %d", i, i-1)
+		}
+	}
+	if err := line.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := line.SetValue(0); err == nil {
+		t.Fatal("a closed line was set")
+	}
+	if _, err := gpio.Output(20, 0); err != nil {
+		t.Fatal("a released line could not be requested", err)
+	}
+	gpio.Reset()
+	if len(gpio.Transitions(20)) != 0 {
+		t.Fatal("transitions were not reset")
+	}
+}
+
+func TestSimGPIOInject(t *testing.T) {
+	gpio := NewSimGPIO()
+	var edges []Edge
+	line, err := gpio.Input(5, func(edge Edge) {
+		edges = append(edges, edge)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []int{1, 1, 0, 0, 1} {
+		gpio.Inject(5, value)
+	}
+	rising := []bool{true, false, true}
+	if len(edges) != len(rising) {
+		t.Fatalf("got %d edges, want %d", len(edges), len(rising))
+	}
+	for i, edge := range edges {
+		if edge.Offset != 5 || edge.Rising != rising[i] {
+			t.Fatalf("edge %d is %+v", i, edge)
+		}
+	}
+	if value, err := line.Value(); err != nil || value != 1 {
+		t.Fatal(value, err)
+	}
+	line.Close()
+	gpio.Inject(5, 0)
+	if len(edges) != len(rising) {
+		t.Fatal("a released line called its handler")
+	}
+}
+
+func TestSoftPWMDuty(t *testing.T) {
+	for _, duty := range []float64{0, .25, .75, 1} {
+		gpio := NewSimGPIO()
+		line, err := gpio.Output(16, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pwm := NewSoftPWM(line, SoftPWMPeriod)
+		pwm.SetDuty(duty)
+		time.Sleep(2 * SoftPWMPeriod)
+		from := gpio.Now()
+		time.Sleep(20 * SoftPWMPeriod)
+		to := gpio.Now()
+		measured := gpio.Duty(16, from, to)
+		pwm.Close()
+		if math.Abs(measured-duty) > .1 {
+			t.Fatalf("duty %f measured %f", duty, measured)
+		}
+	}
+}
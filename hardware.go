@@ -0,0 +1,278 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// OdometryPeriod is the period at which the odometry integrates the encoder ticks
+const OdometryPeriod = 50 * time.Millisecond
+
+// Hardware is the hardware opened from a profile
+type Hardware struct {
+	GPIO    GPIO
+	PCA9685 *PCA9685
+	L298N   *L298N
+	// Watchdog stops the tracks when commands stop arriving
+	Watchdog *Watchdog
+	// Drive drives the tracks of the L298N with ramping and the watchdog
+	Drive DifferentialDrive
+	// Tilt is the up down servo
+	Tilt *Servo
+	// Pan is the left right servo
+	Pan *Servo
+	// Odometry is the dead reckoning from the wheel encoders, nil without encoders
+	Odometry *Odometry
+	// Rangefinder is the ultrasonic rangefinder, nil without one
+	Rangefinder *Rangefinder
+	// IMU is the imu, nil without one
+	IMU *IMU
+	// Battery is the battery monitor, nil without one
+	Battery *Battery
+
+	closers []func() error
+}
+
+// OpenHardware opens the hardware described by a profile
+func OpenHardware(p Profile) (h *Hardware, err error) {
+	h = &Hardware{}
+	defer func() {
+		if err != nil {
+			h.Close()
+		}
+	}()
+
+	if p.Chip == ChipSim {
+		h.GPIO = NewSimGPIO()
+	} else {
+		h.GPIO = NewChipGPIO(p.Chip)
+	}
+	h.closers = append(h.closers, h.GPIO.Close)
+
+	if p.PWM.Backend == PWMPCA9685 {
+		device, err := OpenI2C(p.PWM.I2CBus, p.PWM.Address)
+		if err != nil {
+			return h, err
+		}
+		h.PCA9685, err = NewPCA9685(device, p.PWM.Frequency)
+		if err != nil {
+			device.Close()
+			return h, err
+		}
+		h.closers = append(h.closers, h.PCA9685.Close)
+	}
+
+	var lines []Line
+	closeLines := func() {
+		for _, line := range lines {
+			line.Close()
+		}
+	}
+	output := func(offset int) (Line, error) {
+		line, err := h.GPIO.Output(offset, 0)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+		return line, nil
+	}
+	enable := func(t TrackProfile) (PWM, error) {
+		switch p.PWM.Backend {
+		case PWMSysfs:
+			pwm, err := NewSysfsPWM(SysfsPWMRoot, p.PWM.SysfsChip, t.Channel, HardPWMPeriod)
+			if err == nil {
+				return pwm, nil
+			}
+			fmt.Printf("%v, falling back to soft pwm\n", err)
+		case PWMPCA9685:
+			return h.PCA9685.Channel(t.Channel), nil
+		}
+		line, err := output(t.Enable)
+		if err != nil {
+			return nil, err
+		}
+		return NewSoftPWM(line, SoftPWMPeriod), nil
+	}
+	motor := func(t TrackProfile) (Motor, error) {
+		a, err := output(t.A)
+		if err != nil {
+			return Motor{}, err
+		}
+		b, err := output(t.B)
+		if err != nil {
+			return Motor{}, err
+		}
+		pwm, err := enable(t)
+		if err != nil {
+			return Motor{}, err
+		}
+		return Motor{
+			A:      a,
+			B:      b,
+			Enable: pwm,
+			Invert: t.Invert,
+			Trim:   t.Trim,
+		}, nil
+	}
+	left, err := motor(p.Left)
+	if err != nil {
+		closeLines()
+		return h, err
+	}
+	right, err := motor(p.Right)
+	if err != nil {
+		left.Enable.Close()
+		closeLines()
+		return h, err
+	}
+	h.L298N = NewL298NMotors(left, right)
+	h.Watchdog = NewWatchdog(NewRampDrive(h.L298N, p.Ramp.Limits()), time.Duration(p.Watchdog.Timeout)*time.Millisecond)
+	h.Drive = h.Watchdog
+	h.closers = append(h.closers, h.Drive.Close)
+
+	servo := func(s ServoProfile) (*Servo, error) {
+		if h.PCA9685 != nil {
+			return NewServo(h.PCA9685.Channel(s.Channel), h.PCA9685.Period(), s.Limits()), nil
+		}
+		line, err := h.GPIO.Output(s.Line, 0)
+		if err != nil {
+			return nil, err
+		}
+		return NewServo(NewSoftPWM(line, ServoPeriod), ServoPeriod, s.Limits()), nil
+	}
+	h.Tilt, err = servo(p.Tilt)
+	if err != nil {
+		return h, err
+	}
+	h.closers = append(h.closers, h.Tilt.Close)
+	h.Pan, err = servo(p.Pan)
+	if err != nil {
+		return h, err
+	}
+	h.closers = append(h.closers, h.Pan.Close)
+
+	if p.Encoders.Enabled {
+		e := p.Encoders
+		encoder := func(a, b int, direction func() float64) (*Encoder, error) {
+			counter := NewQuadratureEncoder(a, b)
+			if b < 0 {
+				counter = NewSingleEncoder(a, direction)
+			}
+			lines, err := counter.Open(h.GPIO)
+			for _, line := range lines {
+				h.closers = append(h.closers, line.Close)
+			}
+			return counter, err
+		}
+		left, err := encoder(e.LeftA, e.LeftB, func() float64 {
+			return h.L298N.Tracks().Left
+		})
+		if err != nil {
+			return h, err
+		}
+		right, err := encoder(e.RightA, e.RightB, func() float64 {
+			return h.L298N.Tracks().Right
+		})
+		if err != nil {
+			return h, err
+		}
+		h.Odometry = NewOdometry(left, right, e.TicksPerMeter, e.Width)
+		done := make(chan struct{})
+		var wait sync.WaitGroup
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			h.Odometry.Run(OdometryPeriod, done)
+		}()
+		h.closers = append(h.closers, func() error {
+			close(done)
+			wait.Wait()
+			return nil
+		})
+	}
+
+	if p.Ultrasonic.Enabled {
+		u := p.Ultrasonic
+		trigger, err := h.GPIO.Output(u.Trigger, 0)
+		if err != nil {
+			return h, err
+		}
+		h.Rangefinder = NewRangefinder(trigger, u.Window)
+		echo, err := h.GPIO.Input(u.Echo, h.Rangefinder.Echo)
+		if err != nil {
+			trigger.Close()
+			return h, err
+		}
+		h.Rangefinder.Start()
+		h.closers = append(h.closers, h.Rangefinder.Close, echo.Close)
+	}
+
+	if p.IMU.Enabled {
+		device, err := OpenI2C(p.IMU.I2CBus, p.IMU.Address)
+		if err != nil {
+			return h, err
+		}
+		h.IMU, err = NewMPU6050(device)
+		if err != nil {
+			device.Close()
+			return h, err
+		}
+		tipped := func(tilt float64) {
+			if !h.Watchdog.Latched() {
+				fmt.Printf("tipped over %.0f degrees, emergency stop\n", tilt)
+				h.Watchdog.EStop()
+			}
+		}
+		h.IMU.Start(IMUPeriod, p.IMU.TiltLimit, tipped)
+		h.closers = append(h.closers, h.IMU.Close)
+	}
+
+	if p.Battery.Enabled {
+		device, err := OpenI2C(p.Battery.I2CBus, p.Battery.Address)
+		if err != nil {
+			return h, err
+		}
+		adc, err := NewADS1115(device)
+		if err != nil {
+			device.Close()
+			return h, err
+		}
+		h.Battery = NewBattery(adc, p.Battery.Channel, p.Battery.Divider, p.Battery.Low, p.Battery.Critical)
+		h.closers = append(h.closers, h.Battery.Close)
+		err = h.Battery.Update()
+		if err != nil {
+			return h, err
+		}
+		limit := func(level BatteryLevel, voltage float64) {
+			fmt.Printf("battery %.2f V is %s\n", voltage, level)
+			switch level {
+			case BatteryLow:
+				h.L298N.SetLimit(p.Battery.LowDuty)
+			case BatteryCritical:
+				h.L298N.SetLimit(0)
+			}
+		}
+		limit(h.Battery.Level(), h.Battery.Voltage())
+		// the battery is closed before the drive, so the limit is never set on a closed drive
+		h.Battery.Start(BatteryPeriod, limit)
+	}
+	return h, nil
+}
+
+// Close stops the motors and releases the hardware in the reverse order it was opened,
+// it releases everything once so that later calls do nothing and return nil
+func (h *Hardware) Close() error {
+	var err error
+	for i := len(h.closers) - 1; i >= 0; i-- {
+		if e := h.closers[i](); err == nil {
+			err = e
+		}
+	}
+	h.closers = nil
+	return err
+}
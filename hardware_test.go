@@ -0,0 +1,53 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOpenHardwareDrives(t *testing.T) {
+	profile := DefaultProfile()
+	profile.Chip = ChipSim
+	h, err := OpenHardware(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	gpio := h.GPIO.(*SimGPIO)
+	if limit := h.L298N.Limit(); limit != 1 {
+		t.Fatalf("limit is %f", limit)
+	}
+	err = h.L298N.SetTracks(.5, .5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := gpio.Now()
+	time.Sleep(20 * SoftPWMPeriod)
+	to := gpio.Now()
+	for _, offset := range []int{profile.Left.Enable, profile.Right.Enable} {
+		if duty := gpio.Duty(offset, from, to); duty < .3 || duty > .7 {
+			t.Fatalf("duty of line %d is %f", offset, duty)
+		}
+	}
+}
+
+func TestHardwareCloseTwice(t *testing.T) {
+	profile := DefaultProfile()
+	profile.Chip = ChipSim
+	profile.Encoders.Enabled = true
+	h, err := OpenHardware(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the shutdown steps and the deferred close both close the hardware
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// I2C is a device on an i2c bus
+type I2C interface {
+	// WriteReg writes data to consecutive registers starting at reg
+	WriteReg(reg byte, data ...byte) error
+	// ReadReg reads consecutive registers starting at reg into data
+	ReadReg(reg byte, data []byte) error
+	// Close releases the device
+	Close() error
+}
+
+// i2cSlave is the ioctl for setting the address of an i2c device
+const i2cSlave = 0x0703
+
+// LinuxI2C is an i2c device accessed through /dev/i2c-N
+type LinuxI2C struct {
+	Bus     int
+	Address uint16
+
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// OpenI2C opens the device at address on an i2c bus
+func OpenI2C(bus int, address uint16) (*LinuxI2C, error) {
+	file, err := os.OpenFile(fmt.Sprintf("/dev/i2c-%d", bus), os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), i2cSlave, uintptr(address))
+	if errno != 0 {
+		file.Close()
+		return nil, fmt.Errorf("i2c address 0x%02x on bus %d: %w", address, bus, errno)
+	}
+	return &LinuxI2C{
+		Bus:     bus,
+		Address: address,
+		file:    file,
+	}, nil
+}
+
+// WriteReg writes data to consecutive registers starting at reg
+func (l *LinuxI2C) WriteReg(reg byte, data ...byte) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	_, err := l.file.Write(append([]byte{reg}, data...))
+	return err
+}
+
+// ReadReg reads consecutive registers starting at reg into data
+func (l *LinuxI2C) ReadReg(reg byte, data []byte) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	_, err := l.file.Write([]byte{reg})
+	if err != nil {
+		return err
+	}
+	_, err = l.file.Read(data)
+	return err
+}
+
+// Close releases the device
+func (l *LinuxI2C) Close() error {
+	return l.file.Close()
+}
+
+// I2CWrite is a recorded write to a simulated i2c device
+type I2CWrite struct {
+	Reg  byte
+	Data []byte
+}
+
+// SimI2C is a simulated i2c device with a bank of registers that records writes
+type SimI2C struct {
+	sync.Mutex
+	Registers [256]byte
+	Writes    []I2CWrite
+	Closed    bool
+}
+
+// NewSimI2C creates a new simulated i2c device
+func NewSimI2C() *SimI2C {
+	return &SimI2C{}
+}
+
+// WriteReg writes data to consecutive registers starting at reg
+func (s *SimI2C) WriteReg(reg byte, data ...byte) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.Closed {
+		return fmt.Errorf("i2c device is closed")
+	}
+	s.Writes = append(s.Writes, I2CWrite{
+		Reg:  reg,
+		Data: append([]byte{}, data...),
+	})
+	for i, value := range data {
+		s.Registers[byte(int(reg)+i)] = value
+	}
+	return nil
+}
+
+// ReadReg reads consecutive registers starting at reg into data
+func (s *SimI2C) ReadReg(reg byte, data []byte) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.Closed {
+		return fmt.Errorf("i2c device is closed")
+	}
+	for i := range data {
+		data[i] = s.Registers[byte(int(reg)+i)]
+	}
+	return nil
+}
+
+// Close releases the device
+func (s *SimI2C) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	s.Closed = true
+	return nil
+}
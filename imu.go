@@ -0,0 +1,267 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// MPU6050Address is the default i2c address of a MPU6050
+	MPU6050Address = 0x68
+	// IMUPeriod is the period at which the imu is sampled
+	IMUPeriod = 10 * time.Millisecond
+	// IMUCalibration is the number of samples averaged for the gyro bias
+	IMUCalibration = 100
+)
+
+const (
+	mpu6050SampleRateDivider = 0x19
+	mpu6050Config            = 0x1A
+	mpu6050GyroConfig        = 0x1B
+	mpu6050AccelConfig       = 0x1C
+	mpu6050AccelX            = 0x3B
+	mpu6050PowerManagement   = 0x6B
+	mpu6050WhoAmI            = 0x75
+
+	// mpu6050ClockGyroX selects the x gyro pll as the clock and wakes the chip
+	mpu6050ClockGyroX = 0x01
+	// mpu6050DLPF44 sets the digital low pass filter to 44 Hz
+	mpu6050DLPF44 = 0x03
+	// mpu6050GyroScale is LSB per degree per second at +-250 degrees per second
+	mpu6050GyroScale = 131
+	// mpu6050AccelScale is LSB per g at +-2 g
+	mpu6050AccelScale = 16384
+)
+
+// IMUSample is a sample of acceleration in g and rotation rate in degrees per second
+type IMUSample struct {
+	Accel [3]float64
+	Gyro  [3]float64
+}
+
+// Tilt returns the angle in degrees between the z axis and gravity
+func (s IMUSample) Tilt() float64 {
+	x, y, z := s.Accel[0], s.Accel[1], s.Accel[2]
+	magnitude := math.Sqrt(x*x + y*y + z*z)
+	if magnitude == 0 {
+		return 0
+	}
+	return math.Acos(math.Max(-1, math.Min(1, z/magnitude))) * 180 / math.Pi
+}
+
+// AngleDiff returns the signed difference a - b between two headings in degrees in the range -180 to 180
+func AngleDiff(a, b float64) float64 {
+	return math.Remainder(a-b, 360)
+}
+
+// IMU is a MPU6050 class accelerometer and gyro that integrates yaw into a heading
+type IMU struct {
+	Device I2C
+
+	mutex   sync.Mutex
+	bias    float64
+	heading float64
+	tilt    float64
+	last    time.Time
+	done    chan struct{}
+	wait    sync.WaitGroup
+}
+
+// NewMPU6050 wakes and configures a MPU6050 and calibrates the gyro bias, the robot must be still
+func NewMPU6050(device I2C) (*IMU, error) {
+	var who [1]byte
+	err := device.ReadReg(mpu6050WhoAmI, who[:])
+	if err != nil {
+		return nil, err
+	}
+	switch who[0] {
+	case 0x68, 0x70, 0x71, 0x73:
+	default:
+		return nil, fmt.Errorf("imu who am i 0x%02x is not a mpu6050 class device", who[0])
+	}
+	for _, write := range [][2]byte{
+		{mpu6050PowerManagement, mpu6050ClockGyroX},
+		{mpu6050SampleRateDivider, 9},
+		{mpu6050Config, mpu6050DLPF44},
+		{mpu6050GyroConfig, 0},
+		{mpu6050AccelConfig, 0},
+	} {
+		err := device.WriteReg(write[0], write[1])
+		if err != nil {
+			return nil, err
+		}
+	}
+	m := &IMU{
+		Device: device,
+		done:   make(chan struct{}),
+	}
+	sum := 0.0
+	for i := 0; i < IMUCalibration; i++ {
+		sample, err := m.Read()
+		if err != nil {
+			return nil, err
+		}
+		sum += sample.Gyro[2]
+		time.Sleep(time.Millisecond)
+	}
+	m.bias = sum / IMUCalibration
+	return m, nil
+}
+
+// Read reads a sample of acceleration and rotation rate
+func (m *IMU) Read() (IMUSample, error) {
+	var data [14]byte
+	err := m.Device.ReadReg(mpu6050AccelX, data[:])
+	if err != nil {
+		return IMUSample{}, err
+	}
+	word := func(i int) float64 {
+		return float64(int16(uint16(data[i])<<8 | uint16(data[i+1])))
+	}
+	var sample IMUSample
+	for i := 0; i < 3; i++ {
+		sample.Accel[i] = word(2*i) / mpu6050AccelScale
+		// the temperature sits between the accelerometer and the gyro
+		sample.Gyro[i] = word(8+2*i) / mpu6050GyroScale
+	}
+	return sample, nil
+}
+
+// Update reads a sample and integrates the yaw rate into the heading
+func (m *IMU) Update(now time.Time) error {
+	sample, err := m.Read()
+	if err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if !m.last.IsZero() {
+		dt := now.Sub(m.last).Seconds()
+		m.heading = math.Remainder(m.heading+(sample.Gyro[2]-m.bias)*dt, 360)
+	}
+	m.last = now
+	m.tilt = sample.Tilt()
+	return nil
+}
+
+// Start samples the imu every period until it is closed, tipped is called while the tilt exceeds limit degrees
+func (m *IMU) Start(period time.Duration, limit float64, tipped func(tilt float64)) {
+	m.wait.Add(1)
+	go func() {
+		defer m.wait.Done()
+		t := time.NewTicker(period)
+		defer t.Stop()
+		for {
+			select {
+			case <-m.done:
+				return
+			case now := <-t.C:
+				if err := m.Update(now); err != nil {
+					fmt.Println("imu", err)
+					continue
+				}
+				if tilt := m.Tilt(); tilt > limit && tipped != nil {
+					tipped(tilt)
+				}
+			}
+		}
+	}()
+}
+
+// Heading returns the integrated heading in degrees, counterclockwise is positive
+func (m *IMU) Heading() float64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.heading
+}
+
+// Tilt returns the angle in degrees between the robot's up and gravity
+func (m *IMU) Tilt() float64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.tilt
+}
+
+// Close stops sampling, puts the imu to sleep and releases the device
+func (m *IMU) Close() error {
+	close(m.done)
+	m.wait.Wait()
+	// bit 6 is sleep
+	err := m.Device.WriteReg(mpu6050PowerManagement, 0x40)
+	if e := m.Device.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// HeadingHold corrects track speeds to hold the heading while driving straight, it is safe for concurrent use
+type HeadingHold struct {
+	// Gain is the change in track speed per degree of heading error
+	Gain float64
+
+	mutex   sync.Mutex
+	holding bool
+	target  float64
+}
+
+// Correct returns the tracks corrected towards the heading held since the tracks started driving straight,
+// tracks that are within tolerance of each other are driving straight
+func (h *HeadingHold) Correct(tracks Tracks, heading, tolerance float64) Tracks {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	straight := tracks.Left*tracks.Right > 0 && math.Abs(tracks.Left-tracks.Right) <= tolerance
+	if !straight {
+		h.holding = false
+		return tracks
+	}
+	if !h.holding {
+		h.holding, h.target = true, heading
+	}
+	speed := (tracks.Left + tracks.Right) / 2
+	correction := h.Gain * AngleDiff(heading, h.target)
+	if speed < 0 {
+		// reversing swaps which track turns the robot
+		correction = -correction
+	}
+	return Tracks{
+		Left:  clamp(speed + correction),
+		Right: clamp(speed - correction),
+	}
+}
+
+// Turn is a turn by an angle measured with the imu
+type Turn struct {
+	// Target is the heading to turn to
+	Target float64
+	// Left is true for a counterclockwise turn
+	Left bool
+	// Deadline is when the turn is abandoned
+	Deadline time.Time
+}
+
+// NewTurn starts a turn of angle degrees from heading, positive angles turn left
+func NewTurn(heading, angle float64, timeout time.Duration) *Turn {
+	return &Turn{
+		Target:   math.Remainder(heading+angle, 360),
+		Left:     angle > 0,
+		Deadline: time.Now().Add(timeout),
+	}
+}
+
+// Done returns true once the heading reaches the target or the deadline passes
+func (t *Turn) Done(heading float64) bool {
+	if time.Now().After(t.Deadline) {
+		return true
+	}
+	remaining := AngleDiff(t.Target, heading)
+	if t.Left {
+		return remaining <= 0
+	}
+	return remaining >= 0
+}
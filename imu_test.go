@@ -0,0 +1,279 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/hex"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mpu6050Dumps are dumps of the 14 sample registers from 0x3B of a MPU6050 at +-2 g and +-250 degrees per second,
+// the accelerometer, the temperature and then the gyro, each a big endian word
+var mpu6050Dumps = map[string]string{
+	// flat and still with a little gyro offset
+	"level": "0000 0000 4000 f210 0000 0000 0083",
+	// rolled onto its side
+	"side": "4000 0000 0000 f210 0000 0000 0000",
+	// upside down
+	"upside down": "0000 0000 c000 f210 0000 0000 0000",
+	// tipped 45 degrees forward and turning left at 10 degrees per second
+	"tipped turning": "2d41 0000 2d41 f210 0000 0000 051e",
+	// turning right at 1 degree per second
+	"turning right": "0000 0000 4000 f210 0000 0000 ff7d",
+}
+
+// simMPU6050 creates a simulated MPU6050 with the sample registers loaded from a dump
+func simMPU6050(t *testing.T, dump string) *SimI2C {
+	t.Helper()
+	device := NewSimI2C()
+	device.Registers[mpu6050WhoAmI] = 0x68
+	loadMPU6050(t, device, dump)
+	return device
+}
+
+// loadMPU6050 loads a dump into the sample registers
+func loadMPU6050(t *testing.T, device *SimI2C, dump string) {
+	t.Helper()
+	data := make([]byte, 0, 28)
+	for _, c := range dump {
+		if c != ' ' {
+			data = append(data, byte(c))
+		}
+	}
+	registers, err := hex.DecodeString(string(data))
+	if err != nil || len(registers) != 14 {
+		t.Fatalf("dump %q is not 14 registers: %v", dump, err)
+	}
+	device.Lock()
+	copy(device.Registers[mpu6050AccelX:], registers)
+	device.Unlock()
+}
+
+func TestMPU6050Read(t *testing.T) {
+	tests := []struct {
+		dump  string
+		accel [3]float64
+		gyro  [3]float64
+		tilt  float64
+	}{
+		{"level", [3]float64{0, 0, 1}, [3]float64{0, 0, 1}, 0},
+		{"side", [3]float64{1, 0, 0}, [3]float64{0, 0, 0}, 90},
+		{"upside down", [3]float64{0, 0, -1}, [3]float64{0, 0, 0}, 180},
+		{"tipped turning", [3]float64{.7071, 0, .7071}, [3]float64{0, 0, 10}, 45},
+		{"turning right", [3]float64{0, 0, 1}, [3]float64{0, 0, -1}, 0},
+	}
+	for _, test := range tests {
+		imu := &IMU{
+			Device: simMPU6050(t, mpu6050Dumps[test.dump]),
+		}
+		sample, err := imu.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			if math.Abs(sample.Accel[i]-test.accel[i]) > 1e-3 || math.Abs(sample.Gyro[i]-test.gyro[i]) > .01 {
+				t.Fatalf("%s: sample is %+v, want %v %v", test.dump, sample, test.accel, test.gyro)
+			}
+		}
+		if tilt := sample.Tilt(); math.Abs(tilt-test.tilt) > .1 {
+			t.Fatalf("%s: tilt is %v, want %v", test.dump, tilt, test.tilt)
+		}
+	}
+}
+
+func TestMPU6050Setup(t *testing.T) {
+	device := simMPU6050(t, mpu6050Dumps["level"])
+	imu, err := NewMPU6050(device)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the gyro offset of the level dump is 0x83 = 131 or 1 degree per second
+	if math.Abs(imu.bias-1) > 1e-9 {
+		t.Fatalf("bias is %v, want 1", imu.bias)
+	}
+	if device.Registers[mpu6050PowerManagement] != mpu6050ClockGyroX || device.Registers[mpu6050Config] != mpu6050DLPF44 {
+		t.Fatal("the imu was not woken and configured")
+	}
+	if err := imu.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if device.Registers[mpu6050PowerManagement]&0x40 == 0 || !device.Closed {
+		t.Fatal("the imu was not put to sleep and released")
+	}
+
+	device = simMPU6050(t, mpu6050Dumps["level"])
+	device.Registers[mpu6050WhoAmI] = 0x12
+	if _, err := NewMPU6050(device); err == nil {
+		t.Fatal("a device that is not a mpu6050 was accepted")
+	}
+}
+
+func TestMPU6050Heading(t *testing.T) {
+	device := simMPU6050(t, mpu6050Dumps["level"])
+	imu, err := NewMPU6050(device)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	// still, the gyro offset is removed by the bias
+	for i := 0; i <= 10; i++ {
+		if err := imu.Update(now.Add(time.Duration(i) * 100 * time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if heading := imu.Heading(); math.Abs(heading) > 1e-9 {
+		t.Fatalf("heading drifted to %v while still", heading)
+	}
+	// 10 degrees per second plus the offset for 2 seconds
+	loadMPU6050(t, device, "2d41 0000 2d41 f210 0000 0000 05a1")
+	now = now.Add(time.Second)
+	for i := 1; i <= 20; i++ {
+		if err := imu.Update(now.Add(time.Duration(i) * 100 * time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if heading := imu.Heading(); math.Abs(heading-20) > .01 {
+		t.Fatalf("heading is %v, want 20", heading)
+	}
+	if tilt := imu.Tilt(); math.Abs(tilt-45) > .1 {
+		t.Fatalf("tilt is %v, want 45", tilt)
+	}
+}
+
+func TestMPU6050Close(t *testing.T) {
+	device := simMPU6050(t, mpu6050Dumps["level"])
+	imu, err := NewMPU6050(device)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mutex sync.Mutex
+	tips := 0
+	imu.Start(time.Millisecond, 45, func(tilt float64) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		tips++
+	})
+	loadMPU6050(t, device, mpu6050Dumps["side"])
+	deadline := time.Now().Add(time.Second)
+	for {
+		mutex.Lock()
+		tipped := tips > 0
+		mutex.Unlock()
+		if tipped {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("tipping onto its side was not noticed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := imu.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the sampling has stopped by the time the device is closed
+	mutex.Lock()
+	closed := tips
+	mutex.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	mutex.Lock()
+	defer mutex.Unlock()
+	if tips != closed {
+		t.Fatal("the imu was sampled after it was closed")
+	}
+}
+
+func TestAngleDiff(t *testing.T) {
+	tests := []struct {
+		a, b, diff float64
+	}{
+		{10, 0, 10},
+		{0, 10, -10},
+		{170, -170, -20},
+		{-170, 170, 20},
+		{359, 1, -2},
+	}
+	for _, test := range tests {
+		if diff := AngleDiff(test.a, test.b); math.Abs(diff-test.diff) > 1e-9 {
+			t.Fatalf("AngleDiff(%v, %v) = %v, want %v", test.a, test.b, diff, test.diff)
+		}
+	}
+}
+
+func TestTurnDone(t *testing.T) {
+	tests := []struct {
+		name     string
+		heading  float64
+		angle    float64
+		headings []float64
+		done     []bool
+	}{
+		{"left", 0, 30, []float64{0, 15, 29, 30, 35}, []bool{false, false, false, true, true}},
+		{"right", 0, -30, []float64{0, -15, -29, -31}, []bool{false, false, false, true}},
+		{"left across 180", 170, 30, []float64{170, 179, -170, -160}, []bool{false, false, false, true}},
+		{"right across 180", -170, -30, []float64{-170, 179, 170, 160}, []bool{false, false, false, true}},
+	}
+	for _, test := range tests {
+		turn := NewTurn(test.heading, test.angle, time.Minute)
+		for i, heading := range test.headings {
+			if done := turn.Done(heading); done != test.done[i] {
+				t.Fatalf("%s: done at %v is %v, want %v", test.name, heading, done, test.done[i])
+			}
+		}
+	}
+	turn := NewTurn(0, 30, -time.Second)
+	if !turn.Done(0) {
+		t.Fatal("a turn past its deadline is not done")
+	}
+}
+
+func TestHeadingHold(t *testing.T) {
+	hold := HeadingHold{
+		Gain: .02,
+	}
+	const tolerance = .1
+	tests := []struct {
+		name    string
+		tracks  Tracks
+		heading float64
+		want    Tracks
+	}{
+		{"starts holding", Tracks{Left: .5, Right: .5}, 10, Tracks{Left: .5, Right: .5}},
+		{"drifted left", Tracks{Left: .5, Right: .5}, 15, Tracks{Left: .6, Right: .4}},
+		{"drifted right", Tracks{Left: .5, Right: .55}, 5, Tracks{Left: .425, Right: .625}},
+		{"reversing drifted left", Tracks{Left: -.5, Right: -.5}, 15, Tracks{Left: -.6, Right: -.4}},
+		{"saturated", Tracks{Left: 1, Right: 1}, 40, Tracks{Left: 1, Right: .4}},
+		{"turning stops holding", Tracks{Left: -.5, Right: .5}, 40, Tracks{Left: -.5, Right: .5}},
+		{"holds the new heading", Tracks{Left: .5, Right: .5}, 90, Tracks{Left: .5, Right: .5}},
+		{"and corrects to it", Tracks{Left: .5, Right: .5}, 85, Tracks{Left: .4, Right: .6}},
+		{"stopped", Tracks{}, 0, Tracks{}},
+	}
+	for _, test := range tests {
+		got := hold.Correct(test.tracks, test.heading, tolerance)
+		if math.Abs(got.Left-test.want.Left) > 1e-9 || math.Abs(got.Right-test.want.Right) > 1e-9 {
+			t.Fatalf("%s: tracks are %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestHeadingHoldConcurrent(t *testing.T) {
+	// the manual loop and the auto mode loop both send commands
+	hold := HeadingHold{
+		Gain: .02,
+	}
+	done := make(chan bool)
+	for i := 0; i < 2; i++ {
+		go func() {
+			for j := 0; j < 1000; j++ {
+				hold.Correct(Tracks{Left: .5, Right: .5}, float64(j%20), .1)
+			}
+			done <- true
+		}()
+	}
+	<-done
+	<-done
+}
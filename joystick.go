@@ -0,0 +1,87 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "math"
+
+const (
+	// AxisLeftX is the horizontal axis of the left stick
+	AxisLeftX = 0
+	// AxisLeftY is the vertical axis of the left stick
+	AxisLeftY = 1
+	// AxisTrigger is the speed trigger
+	AxisTrigger = 2
+	// AxisRightX is the horizontal axis of the right stick
+	AxisRightX = 3
+	// AxisRightY is the vertical axis of the right stick
+	AxisRightY = 4
+	// Axes is the number of axes
+	Axes = 5
+)
+
+const (
+	// DriveTank drives each track with the vertical axis of a stick
+	DriveTank = "tank"
+	// DriveArcade drives with the left stick, vertical is throttle and horizontal is steering
+	DriveArcade = "arcade"
+)
+
+// Device is a joystick that can be unplugged
+type Device interface {
+	// Attached returns true while the joystick is plugged in
+	Attached() bool
+}
+
+// Repeat returns true if the main loop repeats the manual command to feed the watchdog, a held stick sends
+// no events so the command is repeated while a joystick is attached, and the watchdog only stops the tracks
+// when the loop stalls or the joystick is unplugged
+func Repeat(mode Mode, devices ...Device) bool {
+	if mode != ModeManual {
+		return false
+	}
+	for _, device := range devices {
+		if device.Attached() {
+			return true
+		}
+	}
+	return false
+}
+
+// Shape maps a raw axis value to the range -1 to 1, values inside the deadzone are zero
+// and expo from 0 to 1 blends in a cubic curve for finer control near the center
+func Shape(value int16, deadzone, expo float64) float64 {
+	x := math.Max(float64(value)/math.MaxInt16, -1)
+	magnitude := math.Abs(x)
+	if magnitude <= deadzone {
+		return 0
+	}
+	magnitude = (magnitude - deadzone) / (1 - deadzone)
+	magnitude = (1-expo)*magnitude + expo*magnitude*magnitude*magnitude
+	return math.Copysign(magnitude, x)
+}
+
+// Arcade mixes a throttle and steering into track speeds, positive steering turns right
+func Arcade(throttle, steer float64) Tracks {
+	left, right := throttle+steer, throttle-steer
+	scale := math.Max(1, math.Max(math.Abs(left), math.Abs(right)))
+	return Tracks{
+		Left:  left / scale,
+		Right: right / scale,
+	}
+}
+
+// Tracks maps the joystick axes to track speeds, pushing a stick up is forward
+func (j JoystickProfile) Tracks(axis [Axes]int16) Tracks {
+	shape := func(a int) float64 {
+		return Shape(axis[a], j.Deadzone, j.Expo)
+	}
+	if j.Mode == DriveArcade {
+		return Arcade(-shape(AxisLeftY), shape(AxisLeftX))
+	}
+	return Tracks{
+		Left:  -shape(AxisLeftY),
+		Right: -shape(AxisRightY),
+	}
+}
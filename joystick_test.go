@@ -0,0 +1,109 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestShape(t *testing.T) {
+	// the axis value at a fraction of full travel
+	at := func(fraction float64) int16 {
+		return int16(fraction * math.MaxInt16)
+	}
+	tests := []struct {
+		name     string
+		value    int16
+		deadzone float64
+		expo     float64
+		want     float64
+	}{
+		{"center", 0, .1, 0, 0},
+		{"inside the deadzone", at(.05), .1, 0, 0},
+		{"edge of the deadzone", at(.1), .1, 0, 0},
+		{"edge of the deadzone backward", -at(.1), .1, 0, 0},
+		{"halfway past the deadzone", at(.55), .1, 0, .5},
+		{"halfway past the deadzone backward", -at(.55), .1, 0, -.5},
+		{"full", math.MaxInt16, .1, 0, 1},
+		{"full with expo", math.MaxInt16, .1, .3, 1},
+		// the negative end has one more step than the positive end
+		{"saturates", math.MinInt16, .1, 0, -1},
+		{"saturates with expo", math.MinInt16, .1, 1, -1},
+		{"cubic", at(.5), 0, 1, .125},
+		{"blend", at(.5), 0, .5, .3125},
+	}
+	for _, test := range tests {
+		if shaped := Shape(test.value, test.deadzone, test.expo); math.Abs(shaped-test.want) > 1e-4 {
+			t.Fatalf("%s: Shape(%d, %v, %v) = %v, want %v", test.name, test.value, test.deadzone, test.expo, shaped, test.want)
+		}
+	}
+	// just past the deadzone the speed starts from zero
+	if shaped := Shape(at(.1)+2, .1, 0); shaped <= 0 || shaped > 1e-4 {
+		t.Fatalf("just past the deadzone the speed is %v", shaped)
+	}
+}
+
+func TestArcade(t *testing.T) {
+	tests := []struct {
+		name            string
+		throttle, steer float64
+		want            Tracks
+	}{
+		{"stopped", 0, 0, Tracks{}},
+		{"forward", 1, 0, Tracks{Left: 1, Right: 1}},
+		{"backward", -.5, 0, Tracks{Left: -.5, Right: -.5}},
+		// positive steering turns right, the left track drives forward
+		{"turn right in place", 0, 1, Tracks{Left: 1, Right: -1}},
+		{"turn left in place", 0, -.5, Tracks{Left: -.5, Right: .5}},
+		{"arc right", .5, .25, Tracks{Left: .75, Right: .25}},
+		// the mix is scaled down to keep the ratio of the tracks
+		{"saturated", 1, 1, Tracks{Left: 1, Right: 0}},
+		{"saturated backward", -1, .5, Tracks{Left: -1. / 3, Right: -1}},
+	}
+	for _, test := range tests {
+		tracks := Arcade(test.throttle, test.steer)
+		if math.Abs(tracks.Left-test.want.Left) > 1e-9 || math.Abs(tracks.Right-test.want.Right) > 1e-9 {
+			t.Fatalf("%s: Arcade(%v, %v) = %+v, want %+v", test.name, test.throttle, test.steer, tracks, test.want)
+		}
+	}
+}
+
+func TestJoystickTracks(t *testing.T) {
+	var axis [Axes]int16
+	// pushing a stick up is a negative axis value
+	axis[AxisLeftY], axis[AxisRightY] = math.MinInt16, math.MaxInt16
+	tank := JoystickProfile{Mode: DriveTank, Deadzone: .1}
+	if tracks := tank.Tracks(axis); tracks != (Tracks{Left: 1, Right: -1}) {
+		t.Fatalf("tank tracks are %+v", tracks)
+	}
+	axis[AxisLeftY], axis[AxisLeftX] = 0, math.MaxInt16
+	arcade := JoystickProfile{Mode: DriveArcade, Deadzone: .1}
+	if tracks := arcade.Tracks(axis); tracks != (Tracks{Left: 1, Right: -1}) {
+		t.Fatalf("a pure right turn drives the tracks at %+v", tracks)
+	}
+}
+
+func TestRepeat(t *testing.T) {
+	attached, unplugged := &fakeDevice{}, &fakeDevice{unplugged: true}
+	tests := []struct {
+		name    string
+		mode    Mode
+		devices []Device
+		want    bool
+	}{
+		{"attached", ModeManual, []Device{attached}, true},
+		{"one of two attached", ModeManual, []Device{unplugged, attached}, true},
+		{"unplugged", ModeManual, []Device{unplugged}, false},
+		{"no joystick", ModeManual, nil, false},
+		// auto mode sends its own commands
+		{"auto", ModeAuto, []Device{attached}, false},
+	}
+	for _, test := range tests {
+		if repeat := Repeat(test.mode, test.devices...); repeat != test.want {
+			t.Fatalf("%s: repeat is %v, want %v", test.name, repeat, test.want)
+		}
+	}
+}
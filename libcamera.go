@@ -5,64 +5,125 @@
 package main
 
 import (
-	"io"
+	"context"
+	"fmt"
 	"os"
 	"os/exec"
+	"strconv"
 	"time"
 
 	"github.com/zergon321/reisen"
 )
 
-// StreamCamera is a camera that is from a stream
+// StreamCamera is a camera that decodes the h264 stream a command writes to its standard output
 type StreamCamera struct {
-	Stream bool
-	Images chan Frame
+	cameraStream
+	// Command is the command that streams h264, libcamera-vid by default
+	Command string
+	// Arguments are the arguments of the command, empty generates the libcamera-vid arguments from the mode
+	Arguments []string
+	// Mode is the requested frame size and rate
+	Mode CameraMode
 }
 
-// NewStreamCamera creates a new streaming camera
-func NewStreamCamera() *StreamCamera {
+// NewStreamCamera creates a new streaming camera that captures in mode with a command and its arguments,
+// an empty command runs libcamera-vid
+func NewStreamCamera(mode CameraMode, command string, arguments ...string) *StreamCamera {
+	if command == "" {
+		command = "libcamera-vid"
+	}
 	return &StreamCamera{
-		Stream: true,
-		Images: make(chan Frame, 1),
+		cameraStream: newCameraStream(CameraInfo{
+			Name:   "center",
+			Device: command,
+			Format: "h264",
+		}, mode.Rate),
+		Command:   command,
+		Arguments: arguments,
+		Mode:      mode,
 	}
 }
 
-// Start starts streaming
-func (sc *StreamCamera) Start() {
-	skip := 0
-	command := exec.Command("libcamera-vid", "-t", "0", "-o", "-")
-	input, err := command.StdoutPipe()
+// Args returns the arguments of the command
+func (sc *StreamCamera) Args() []string {
+	if len(sc.Arguments) > 0 {
+		return sc.Arguments
+	}
+	args := []string{"-t", "0", "-o", "-"}
+	if sc.Mode.Width > 0 && sc.Mode.Height > 0 {
+		args = append(args, "--width", strconv.Itoa(sc.Mode.Width), "--height", strconv.Itoa(sc.Mode.Height))
+	}
+	if sc.Mode.FPS > 0 {
+		args = append(args, "--framerate", strconv.FormatFloat(sc.Mode.FPS, 'g', -1, 64))
+	}
+	return args
+}
+
+// Start starts the command and decodes its stream until ctx is canceled or Stop is called
+func (sc *StreamCamera) Start(ctx context.Context) error {
+	ctx, err := sc.begin(ctx)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	err = command.Start()
+	reader, writer, err := os.Pipe()
 	if err != nil {
-		panic(err)
+		sc.end(err)
+		return err
 	}
-	output, err := os.OpenFile("center", os.O_RDWR, 0600)
+	// the context kills the command when streaming ends
+	command := exec.CommandContext(ctx, sc.Command, sc.Args()...)
+	command.Stdout = writer
+	err = command.Start()
+	// the command has its own copy of the writer, so the stream ends when the command exits
+	writer.Close()
 	if err != nil {
-		panic(err)
+		reader.Close()
+		sc.end(err)
+		return err
 	}
-	go io.Copy(output, input)
-	defer close(sc.Images)
+	go func() {
+		// ffmpeg reads the pipe directly by its file descriptor
+		err := sc.decode(ctx, fmt.Sprintf("pipe:%d", reader.Fd()))
+		stopped := ctx.Err() != nil
+		sc.halt()
+		// reap the command, a stream that ends by itself ends with the exit status of the command
+		if e := command.Wait(); err == nil && !stopped && e != nil {
+			err = fmt.Errorf("%s: %w", sc.Command, e)
+		}
+		reader.Close()
+		sc.end(err)
+	}()
+	return nil
+}
 
-	media, err := reisen.NewMedia("center")
+// decode decodes the stream at a url into frames
+func (sc *StreamCamera) decode(ctx context.Context, url string) error {
+	media, err := reisen.NewMedia(url)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	defer media.Close()
 	err = media.OpenDecode()
 	if err != nil {
-		panic(err)
+		return err
 	}
+	defer media.CloseDecode()
+	defer func() {
+		for _, stream := range media.Streams() {
+			if stream.Opened() {
+				stream.Close()
+			}
+		}
+	}()
 
-	start, count := time.Now(), 0.0
-	_ = start
-	for sc.Stream {
+	for ctx.Err() == nil {
 		var pkt *reisen.Packet
 		pkt, gotPacket, err := media.ReadPacket()
 		if err != nil {
-			panic(err)
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
 		}
 
 		if !gotPacket {
@@ -75,13 +136,20 @@ func (sc *StreamCamera) Start() {
 			if !s.Opened() {
 				err = s.Open()
 				if err != nil {
-					panic(err)
+					return err
 				}
+				info := sc.Info()
+				info.Width, info.Height = s.Width(), s.Height()
+				if num, den := s.FrameRate(); num > 0 && den > 0 {
+					info.FPS = float64(num) / float64(den)
+				}
+				sc.setInfo(info)
+				fmt.Println("camera", info)
 			}
 
 			videoFrame, gotFrame, err := s.ReadVideoFrame()
 			if err != nil {
-				panic(err)
+				return err
 			}
 
 			if !gotFrame {
@@ -91,37 +159,27 @@ func (sc *StreamCamera) Start() {
 			if videoFrame == nil {
 				continue
 			}
-			count++
-
-			//fmt.Println("center", count/float64(time.Since(start).Seconds()))
 
-			if skip < 10 {
-				skip++
-			} else {
-				skip = 0
+			if !sc.capture(time.Now()) {
 				continue
 			}
 
-			select {
-			case sc.Images <- Frame{
+			sc.send(Frame{
 				Frame: videoFrame.Image(),
-			}:
-			default:
-				//fmt.Println("drop center")
-			}
+			})
 		case reisen.StreamAudio:
 			s := media.Streams()[pkt.StreamIndex()].(*reisen.AudioStream)
 
 			if !s.Opened() {
 				err = s.Open()
 				if err != nil {
-					panic(err)
+					return err
 				}
 			}
 
 			audioFrame, gotFrame, err := s.ReadAudioFrame()
 			if err != nil {
-				panic(err)
+				return err
 			}
 
 			if !gotFrame {
@@ -133,21 +191,5 @@ func (sc *StreamCamera) Start() {
 			}
 		}
 	}
-
-	for _, stream := range media.Streams() {
-		err = stream.Close()
-		if err != nil {
-			panic(err)
-		}
-	}
-
-	err = media.CloseDecode()
-	if err != nil {
-		panic(err)
-	}
-
-	err = command.Wait()
-	if err != nil {
-		panic(err)
-	}
+	return nil
 }
@@ -0,0 +1,77 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestStreamCameraArgs(t *testing.T) {
+	camera := NewStreamCamera(CameraMode{Width: 640, Height: 480, FPS: 30}, "")
+	if camera.Command != "libcamera-vid" {
+		t.Fatalf("the default command is %s", camera.Command)
+	}
+	want := "-t 0 -o - --width 640 --height 480 --framerate 30"
+	if args := strings.Join(camera.Args(), " "); args != want {
+		t.Fatalf("the arguments are %q, want %q", args, want)
+	}
+	camera = NewStreamCamera(CameraMode{Width: 640, Height: 480}, "ffmpeg", "-i", "clip.h264")
+	if args := strings.Join(camera.Args(), " "); args != "-i clip.h264" {
+		t.Fatalf("the given arguments became %q", args)
+	}
+}
+
+func TestStreamCamera(t *testing.T) {
+	clip := writeClip(t, 60, 30)
+	pid := filepath.Join(t.TempDir(), "pid")
+	// the command writes its pid and streams the clip, then keeps the pipe open like a camera
+	camera := NewStreamCamera(CameraMode{}, "sh", "-c", `echo $$ > "$1"; exec tail -c +1 -f "$2"`, "camera", pid, clip)
+	if err := camera.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	frames, _ := readFrames(t, camera, 3)
+	for i := 1; i < len(frames); i++ {
+		if frames[i] <= frames[i-1] {
+			t.Fatalf("the frames are %v", frames)
+		}
+	}
+	if info := camera.Info(); info.Width != 60*clipBar || info.Height != 16 {
+		t.Fatalf("the stream is %dx%d", info.Width, info.Height)
+	}
+	data, err := os.ReadFile(pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	process, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- camera.Stop()
+	}()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stop hung on the open pipe")
+	}
+	// the command was killed and reaped
+	if err := syscall.Kill(process, 0); err != syscall.ESRCH {
+		t.Fatalf("the command is still running: %v", err)
+	}
+	for range camera.Frames() {
+	}
+}
@@ -7,6 +7,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"image"
@@ -14,20 +15,34 @@ import (
 	"image/color/palette"
 	"image/draw"
 	"image/gif"
+	"image/png"
 	"math"
 	"math/rand"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	. "github.com/pointlander/matrix"
 
 	"github.com/veandco/go-sdl2/sdl"
-	"github.com/warthog618/gpiod"
-	"github.com/warthog618/gpiod/device/rpi"
 )
 
 var joysticks = make(map[int]*sdl.Joystick)
 
+// devices returns the open joysticks
+func devices() []Device {
+	var open []Device
+	for _, joystick := range joysticks {
+		if joystick != nil {
+			open = append(open, joystick)
+		}
+	}
+	return open
+}
+
 type (
 	// JoystickState is the state of a joystick
 	JoystickState uint
@@ -35,6 +50,8 @@ type (
 	Mode uint
 	// Camera is a camera
 	TypeCamera uint
+	// Action is an action chosen in auto mode
+	Action uint
 )
 
 const (
@@ -64,6 +81,19 @@ const (
 	TypeCameraNone
 )
 
+const (
+	// ActionForward drives both tracks forward
+	ActionForward Action = iota
+	// ActionLeft turns left in place
+	ActionLeft
+	// ActionRight turns right in place
+	ActionRight
+	// ActionStop stops both tracks
+	ActionStop
+	// ActionBackward drives both tracks backward
+	ActionBackward
+)
+
 const (
 	// Rate is the learning rate
 	Rate = .3
@@ -73,6 +103,14 @@ const (
 	Nets = 16
 	// Pixels is the number of pixels to sample
 	Pixels = 128
+	// SoftPWMPeriod is the period of the soft pwm on the motor enable lines
+	SoftPWMPeriod = 10 * time.Millisecond
+	// HardPWMPeriod is the period of the sysfs pwm on the motor enable pins
+	HardPWMPeriod = time.Millisecond
+	// ServoStep is the number of degrees a hat press moves a servo
+	ServoStep = 9
+	// TrimStep is the change in track trim of a shoulder button press
+	TrimStep = .01
 )
 
 // Coord is a coordinate
@@ -84,6 +122,30 @@ type Coord struct {
 var (
 	// FlagPicture is the flag for taking a picture
 	FlagPicture = flag.Bool("picture", false, "take a picture")
+	// FlagSim is the flag for using simulated gpio
+	FlagSim = flag.Bool("sim", false, "use simulated gpio")
+	// FlagConfig is the flag for the hardware profile
+	FlagConfig = flag.String("config", "", "json hardware profile, the yahboom g1 wiring is used by default")
+	// FlagReplay is the flag for replaying video files instead of the cameras
+	FlagReplay = flag.String("replay", "", "comma separated video files replayed as the center, left and right cameras")
+	// FlagPace is the flag for replaying at the recorded frame rate
+	FlagPace = flag.Bool("pace", true, "replay at the recorded frame rate")
+	// FlagLoop is the flag for looping the replay
+	FlagLoop = flag.Bool("loop", false, "loop the replay")
+	// FlagOffset is the flag for where in the files the replay starts
+	FlagOffset = flag.Duration("offset", 0, "where in the files the replay starts")
+	// FlagSynthetic is the flag for generating test patterns instead of using the cameras
+	FlagSynthetic = flag.String("synthetic", "", "script of test patterns such as bars:30,shapes:100,noise:10,flash:50 shown by every camera")
+	// FlagDepth is the flag for dumping the stereo depth
+	FlagDepth = flag.Bool("depth", false, "dump a rectified stereo pair, its disparity and the column depths")
+	// FlagCalibrate is the flag for calibrating a camera or the stereo pair
+	FlagCalibrate = flag.String("calibrate", "", "calibrate the center, left or right camera or the stereo pair with a checkerboard")
+	// FlagBoard is the flag for the inner corners of the checkerboard
+	FlagBoard = flag.String("board", "9x6", "inner corners of the calibration checkerboard")
+	// FlagSquare is the flag for the size of the squares of the checkerboard
+	FlagSquare = flag.Float64("square", .025, "size in meters of a square of the calibration checkerboard")
+	// FlagViews is the flag for the number of views of the checkerboard
+	FlagViews = flag.Int("views", 15, "number of views of the checkerboard to calibrate with")
 )
 
 // String returns a string representation of the JoystickState
@@ -101,6 +163,12 @@ func (j JoystickState) String() string {
 // Frame is a video frame
 type Frame struct {
 	Frame image.Image
+	// Source is the name of the camera that captured the frame
+	Source string
+	// Sequence counts the frames captured by the camera, gaps are dropped frames
+	Sequence uint64
+	// Time is when the frame was captured
+	Time  time.Time
 	DCT   [][]float64
 	Query Matrix
 	Key   Matrix
@@ -227,64 +295,106 @@ func (f *FrameProcessor) Process(output chan Frame) {
 	}
 }
 
-func picture() {
-	stream := NewStreamCamera()
-	left := NewV4LCamera()
-	right := NewV4LCamera()
-	go stream.Start()
-	go left.Start("/dev/videol")
-	go right.Start("/dev/videor")
-	i, j, k := 0, 0, 0
-	var c, l, r []*image.Paletted
-	for i < 32 || j < 32 || k < 32 {
-		select {
-		case img := <-stream.Images:
-			opts := gif.Options{
-				NumColors: 256,
-				Drawer:    draw.FloydSteinberg,
-			}
-			bounds := img.Frame.Bounds()
-			paletted := image.NewPaletted(bounds, palette.Plan9[:opts.NumColors])
-			if opts.Quantizer != nil {
-				paletted.Palette = opts.Quantizer.Quantize(make(color.Palette, 0, opts.NumColors), img.Frame)
-			}
-			opts.Drawer.Draw(paletted, bounds, img.Frame, image.Point{})
-			c = append(c, paletted)
-			fmt.Println("center", i)
-			i++
-		case img := <-left.Images:
-			opts := gif.Options{
-				NumColors: 256,
-				Drawer:    draw.FloydSteinberg,
-			}
-			bounds := img.Frame.Bounds()
-			paletted := image.NewPaletted(bounds, palette.Plan9[:opts.NumColors])
-			if opts.Quantizer != nil {
-				paletted.Palette = opts.Quantizer.Quantize(make(color.Palette, 0, opts.NumColors), img.Frame)
-			}
-			opts.Drawer.Draw(paletted, bounds, img.Frame, image.Point{})
-			l = append(l, paletted)
-			fmt.Println("left", j)
-			j++
-		case img := <-right.Images:
-			opts := gif.Options{
-				NumColors: 256,
-				Drawer:    draw.FloydSteinberg,
-			}
-			bounds := img.Frame.Bounds()
-			paletted := image.NewPaletted(bounds, palette.Plan9[:opts.NumColors])
-			if opts.Quantizer != nil {
-				paletted.Palette = opts.Quantizer.Quantize(make(color.Palette, 0, opts.NumColors), img.Frame)
+// NewCameras creates the center, left and right cameras in the order of TypeCamera,
+// the cameras replay video files or generate test patterns when the replay or synthetic flags are set
+// and remove the lens distortion of the cameras with a calibration
+func NewCameras(profile Profile) ([]Camera, error) {
+	names := []string{"center", "left", "right"}
+	modes := []CameraMode{profile.Cameras.Center, profile.Cameras.Left, profile.Cameras.Right}
+	calibrations := make([]*CameraCalibration, len(modes))
+	for i, mode := range modes {
+		if mode.Calibration == "" {
+			continue
+		}
+		calibration, err := LoadCameraCalibration(mode.Calibration)
+		if err != nil {
+			return nil, fmt.Errorf("%s camera: %w", names[i], err)
+		}
+		calibrations[i] = &calibration
+	}
+	switch {
+	case *FlagSynthetic != "":
+		script, err := ParseScript(*FlagSynthetic)
+		if err != nil {
+			return nil, err
+		}
+		cameras := make([]Camera, len(names))
+		for i, name := range names {
+			camera := NewSyntheticModeCamera(name, modes[i], int64(i+1), script...)
+			camera.SetCalibration(calibrations[i])
+			cameras[i] = camera
+		}
+		return cameras, nil
+	case *FlagReplay != "":
+		files := strings.Split(*FlagReplay, ",")
+		if len(files) != len(names) {
+			return nil, fmt.Errorf("replay needs %d files, got %d", len(names), len(files))
+		}
+		options := FileCameraOptions{
+			Pace:   *FlagPace,
+			Loop:   *FlagLoop,
+			Offset: *FlagOffset,
+		}
+		cameras := make([]Camera, len(files))
+		for i, file := range files {
+			camera := NewFileCamera(names[i], file, options)
+			camera.SetRate(modes[i].Rate)
+			camera.SetCalibration(calibrations[i])
+			cameras[i] = camera
+		}
+		return cameras, nil
+	}
+	center := NewStreamCamera(modes[TypeCameraCenter], profile.Cameras.Command, profile.Cameras.Args...)
+	center.SetCalibration(calibrations[TypeCameraCenter])
+	left := NewV4LCamera(names[TypeCameraLeft], "/dev/videol", modes[TypeCameraLeft])
+	left.SetCalibration(calibrations[TypeCameraLeft])
+	right := NewV4LCamera(names[TypeCameraRight], "/dev/videor", modes[TypeCameraRight])
+	right.SetCalibration(calibrations[TypeCameraRight])
+	return []Camera{center, left, right}, nil
+}
+
+func picture(profile Profile) {
+	cameras, err := NewCameras(profile)
+	if err != nil {
+		panic(err)
+	}
+	images := make([][]*image.Paletted, len(cameras))
+	var wait sync.WaitGroup
+	for i, camera := range cameras {
+		err := camera.Start(context.Background())
+		if err != nil {
+			fmt.Println(camera.Info(), err)
+			continue
+		}
+		fmt.Println("camera", camera.Info())
+		wait.Add(1)
+		go func(i int, camera Camera) {
+			defer wait.Done()
+			for img := range camera.Frames() {
+				opts := gif.Options{
+					NumColors: 256,
+					Drawer:    draw.FloydSteinberg,
+				}
+				bounds := img.Frame.Bounds()
+				paletted := image.NewPaletted(bounds, palette.Plan9[:opts.NumColors])
+				if opts.Quantizer != nil {
+					paletted.Palette = opts.Quantizer.Quantize(make(color.Palette, 0, opts.NumColors), img.Frame)
+				}
+				opts.Drawer.Draw(paletted, bounds, img.Frame, image.Point{})
+				fmt.Println(camera.Info().Name, len(images[i]))
+				images[i] = append(images[i], paletted)
+				if len(images[i]) == 32 {
+					return
+				}
 			}
-			opts.Drawer.Draw(paletted, bounds, img.Frame, image.Point{})
-			r = append(r, paletted)
-			fmt.Println("right", k)
-			k++
+		}(i, camera)
+	}
+	wait.Wait()
+	for _, camera := range cameras {
+		if err := camera.Stop(); err != nil {
+			fmt.Println(camera.Info(), err)
 		}
 	}
-	stream.Stream = false
-	left.Stream = false
-	right.Stream = false
 	process := func(name string, images []*image.Paletted) {
 		animation := &gif.GIF{}
 		for _, paletted := range images {
@@ -296,106 +406,312 @@ func picture() {
 		defer f.Close()
 		gif.EncodeAll(f, animation)
 	}
-	process("center.gif", c)
-	process("left.gif", l)
-	process("right.gif", r)
+	for i, camera := range cameras {
+		if len(images[i]) > 0 {
+			process(camera.Info().Name+".gif", images[i])
+		}
+	}
 }
 
-func main() {
-	flag.Parse()
-
-	if *FlagPicture {
-		picture()
-		return
+// OpenStereo loads the calibration and creates the stereo depth module of a profile
+func OpenStereo(profile Profile) (*Stereo, error) {
+	calibration, err := LoadStereoCalibration(profile.Stereo.Calibration)
+	if err != nil {
+		return nil, err
 	}
+	s := profile.Stereo
+	return NewStereo(calibration, s.Matcher(), s.Columns, s.Top, s.Bottom), nil
+}
 
-	var event sdl.Event
-	var running bool
-	sdl.Init(sdl.INIT_JOYSTICK)
-	defer sdl.Quit()
-	sdl.JoystickEventState(sdl.ENABLE)
-	running = true
-	var axis [5]int16
-	joystickLeft := JoystickStateNone
-	joystickRight := JoystickStateNone
-	var speed int16
-	var mode Mode
-
-	in1, err := gpiod.RequestLine("gpiochip0", rpi.GPIO20, gpiod.AsOutput(0))
+func depth(profile Profile) {
+	stereo, err := OpenStereo(profile)
 	if err != nil {
 		panic(err)
 	}
-	in2, err := gpiod.RequestLine("gpiochip0", rpi.GPIO21, gpiod.AsOutput(0))
+	cameras, err := NewCameras(profile)
 	if err != nil {
 		panic(err)
 	}
-	in3, err := gpiod.RequestLine("gpiochip0", rpi.GPIO19, gpiod.AsOutput(0))
+	pair := []Camera{cameras[TypeCameraLeft], cameras[TypeCameraRight]}
+	for i, camera := range pair {
+		err := camera.Start(context.Background())
+		if err != nil {
+			panic(fmt.Errorf("%v: %w", camera.Info(), err))
+		}
+		fmt.Println("camera", camera.Info())
+		go func(left bool, camera Camera) {
+			for frame := range camera.Frames() {
+				stereo.Frame(frame, left)
+			}
+		}(i == 0, camera)
+	}
+	var result *StereoResult
+	select {
+	case result = <-stereo.Results():
+	case <-time.After(10 * time.Second):
+	}
+	for _, camera := range pair {
+		if err := camera.Stop(); err != nil {
+			fmt.Println(camera.Info(), err)
+		}
+	}
+	if result == nil {
+		panic("no stereo pair was matched")
+	}
+	write := func(name string, img image.Image) {
+		f, err := os.Create(name)
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+		err = png.Encode(f, img)
+		if err != nil {
+			panic(err)
+		}
+	}
+	write("left_rectified.png", result.Left)
+	write("right_rectified.png", result.Right)
+	write("disparity.png", result.Disparity.Image(profile.Stereo.MaxDisparity))
+	for i, depth := range result.Depths {
+		fmt.Printf("column %d depth %.2f m\n", i, depth)
+	}
+}
+
+func calibrate(profile Profile) {
+	board, err := ParseBoard(*FlagBoard, *FlagSquare)
 	if err != nil {
 		panic(err)
 	}
-	in4, err := gpiod.RequestLine("gpiochip0", rpi.GPIO26, gpiod.AsOutput(0))
+	if *FlagViews < CalibrationViews {
+		panic(fmt.Errorf("views %d must be at least %d", *FlagViews, CalibrationViews))
+	}
+	// calibrate with the frames as captured
+	profile.Cameras.Center.Calibration = ""
+	profile.Cameras.Left.Calibration = ""
+	profile.Cameras.Right.Calibration = ""
+	cameras, err := NewCameras(profile)
 	if err != nil {
 		panic(err)
 	}
-	ena, err := gpiod.RequestLine("gpiochip0", rpi.GPIO16, gpiod.AsOutput(0))
+	var chosen []Camera
+	switch *FlagCalibrate {
+	case "center":
+		chosen = []Camera{cameras[TypeCameraCenter]}
+	case "left":
+		chosen = []Camera{cameras[TypeCameraLeft]}
+	case "right":
+		chosen = []Camera{cameras[TypeCameraRight]}
+	case "stereo":
+		chosen = []Camera{cameras[TypeCameraLeft], cameras[TypeCameraRight]}
+	default:
+		panic(fmt.Errorf("calibrate %q must be center, left, right or stereo", *FlagCalibrate))
+	}
+	frames := make(chan Frame)
+	var wait sync.WaitGroup
+	for _, camera := range chosen {
+		err := camera.Start(context.Background())
+		if err != nil {
+			panic(fmt.Errorf("%v: %w", camera.Info(), err))
+		}
+		fmt.Println("camera", camera.Info())
+		wait.Add(1)
+		go func(camera Camera) {
+			defer wait.Done()
+			for frame := range camera.Frames() {
+				frames <- frame
+			}
+		}(camera)
+	}
+	go func() {
+		wait.Wait()
+		close(frames)
+	}()
+
+	// a view is kept when the board is found by every chosen camera in frames captured together
+	// and the board has moved since the views already kept
+	views := make([][][]vec2, len(chosen))
+	latest := make([]Frame, len(chosen))
+	var width, height int
+	fmt.Printf("show the %s board to the %s camera in %d poses\n", *FlagBoard, *FlagCalibrate, *FlagViews)
+	for frame := range frames {
+		for i, camera := range chosen {
+			if camera.Info().Name == frame.Source {
+				latest[i] = frame
+			}
+		}
+		paired := true
+		for _, f := range latest {
+			dt := f.Time.Sub(frame.Time)
+			paired = paired && f.Frame != nil && dt < StereoSync && dt > -StereoSync
+		}
+		if !paired {
+			continue
+		}
+		found := make([][]vec2, len(chosen))
+		for i, f := range latest {
+			corners, ok := FindCheckerboard(f.Frame, board)
+			if !ok {
+				paired = false
+				break
+			}
+			found[i] = corners
+		}
+		latest = make([]Frame, len(chosen))
+		if !paired {
+			continue
+		}
+		b := frame.Frame.Bounds()
+		width, height = b.Dx(), b.Dy()
+		moved := true
+		for _, view := range views[0] {
+			distance := 0.0
+			for k := range view {
+				distance += view[k].sub(found[0][k]).norm()
+			}
+			moved = moved && distance/float64(len(view)) > .03*float64(width)
+		}
+		if !moved {
+			continue
+		}
+		for i := range chosen {
+			views[i] = append(views[i], found[i])
+		}
+		fmt.Printf("view %d of %d\n", len(views[0]), *FlagViews)
+		if len(views[0]) == *FlagViews {
+			break
+		}
+	}
+	for _, camera := range chosen {
+		if err := camera.Stop(); err != nil {
+			fmt.Println(camera.Info(), err)
+		}
+	}
+	for range frames {
+	}
+
+	if len(chosen) == 2 {
+		calibration, err := CalibrateStereo(board, views[0], views[1], width, height)
+		if err != nil {
+			panic(err)
+		}
+		err = calibration.Save(profile.Stereo.Calibration)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("stereo baseline %.4f m error %.3f px written to %s\n",
+			vec3(calibration.Translation).norm(), calibration.Error, profile.Stereo.Calibration)
+		return
+	}
+	calibration, err := CalibrateCamera(board, views[0], width, height)
 	if err != nil {
 		panic(err)
 	}
-	enb, err := gpiod.RequestLine("gpiochip0", rpi.GPIO13, gpiod.AsOutput(0))
+	name := *FlagCalibrate + ".json"
+	err = calibration.Save(name)
 	if err != nil {
 		panic(err)
 	}
-	servoUpDown, err := gpiod.RequestLine("gpiochip0", rpi.GPIO9, gpiod.AsOutput(0))
+	fmt.Printf("%s fx %.1f fy %.1f cx %.1f cy %.1f distortion %.4f error %.3f px written to %s\n", *FlagCalibrate,
+		calibration.Fx, calibration.Fy, calibration.Cx, calibration.Cy, calibration.Distortion, calibration.Error, name)
+}
+
+func main() {
+	flag.Parse()
+
+	profile := DefaultProfile()
+	if *FlagConfig != "" {
+		var err error
+		profile, err = LoadProfile(*FlagConfig)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if *FlagPicture {
+		picture(profile)
+		return
+	}
+
+	if *FlagDepth {
+		depth(profile)
+		return
+	}
+
+	if *FlagCalibrate != "" {
+		calibrate(profile)
+		return
+	}
+
+	var event sdl.Event
+	var running bool
+	sdl.Init(sdl.INIT_JOYSTICK)
+	defer sdl.Quit()
+	sdl.JoystickEventState(sdl.ENABLE)
+	running = true
+	var axis [Axes]int16
+	var tracks Tracks
+	var speed int16
+	var mode Mode
+
+	if *FlagSim {
+		profile.Chip = ChipSim
+	}
+	fmt.Printf("hardware profile %s\n", profile.Name)
+	hardware, err := OpenHardware(profile)
 	if err != nil {
 		panic(err)
 	}
-	servoLeftRight, err := gpiod.RequestLine("gpiochip0", rpi.GPIO11, gpiod.AsOutput(0))
+	// the shutdown steps close the hardware, this releases it when a panic skips them
+	defer hardware.Close()
+	drive, servoUpDown, servoLeftRight := hardware.Drive, hardware.Tilt, hardware.Pan
+	pwm := 75
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	unsupervised, err := NewCameras(profile)
 	if err != nil {
 		panic(err)
 	}
-	pwm := 75
-	t := time.Tick(5 * time.Microsecond)
-	go func() {
-		counter, state := 0, 0
-		for {
-			<-t
-			counter++
-			if counter%100 > pwm {
-				state = 1
-			} else {
-				state = 0
-			}
-			ena.SetValue(state)
-			enb.SetValue(state)
+	// a camera that fails is restarted instead of taking the robot down
+	cameras := make([]*Supervisor, len(unsupervised))
+	for i, camera := range unsupervised {
+		cameras[i] = NewSupervisor(camera)
+	}
+	var stereo *Stereo
+	if profile.Stereo.Enabled {
+		stereo, err = OpenStereo(profile)
+		if err != nil {
+			panic(err)
 		}
-	}()
+	}
 
+	// mutex guards the mode, tracks and pwm, which the event loop and the decision loop both change
+	var mutex sync.Mutex
+	hold := HeadingHold{
+		Gain: profile.IMU.HeadingGain,
+	}
+	// update sends the tracks to the drive, the mutex must be held
 	update := func() {
-		switch joystickRight {
-		case JoystickStateUp:
-			in3.SetValue(1)
-			in4.SetValue(0)
-		case JoystickStateDown:
-			in3.SetValue(0)
-			in4.SetValue(1)
-		default:
-			in3.SetValue(0)
-			in4.SetValue(0)
-		}
-		switch joystickLeft {
-		case JoystickStateUp:
-			in1.SetValue(1)
-			in2.SetValue(0)
-		case JoystickStateDown:
-			in1.SetValue(0)
-			in2.SetValue(1)
-		default:
-			in1.SetValue(0)
-			in2.SetValue(0)
+		throttle := float64(100-pwm) / 100
+		command := Tracks{
+			Left:  tracks.Left * throttle,
+			Right: tracks.Right * throttle,
+		}
+		if hardware.IMU != nil && mode == ModeManual {
+			command = hold.Correct(command, hardware.IMU.Heading(), profile.IMU.Straight)
+		}
+		err := drive.SetTracks(command.Left, command.Right)
+		if err != nil && err != ErrEStop {
+			fmt.Println(err)
 		}
 	}
 
+	// the decision loop runs until it is canceled and then closes decided
+	ctx, cancel := context.WithCancel(context.Background())
+	decided := make(chan struct{})
 	go func() {
+		defer close(decided)
 		rng := rand.New(rand.NewSource(32))
 		actionsQ := make([][]float32, 5)
 		for a := range actionsQ {
@@ -438,13 +754,17 @@ func main() {
 			}
 			return index
 		}
-		center, centerProcessor, centerActivations := NewStreamCamera(), NewFrameProcessor(1), make(chan Frame, 8)
-		left, leftProcessor, leftActivations := NewV4LCamera(), NewFrameProcessor(2), make(chan Frame, 8)
-		right, rightProcessor, rightActivations := NewV4LCamera(), NewFrameProcessor(3), make(chan Frame, 8)
-
-		go center.Start()
-		go left.Start("/dev/videol")
-		go right.Start("/dev/videor")
+		processors, activations := make([]*FrameProcessor, len(cameras)), make([]chan Frame, len(cameras))
+		for i, camera := range cameras {
+			processors[i], activations[i] = NewFrameProcessor(int64(i+1)), make(chan Frame, 8)
+			err := camera.Start(context.Background())
+			if err != nil {
+				fmt.Println(camera.Info(), err)
+			}
+		}
+		centerActivations := activations[TypeCameraCenter]
+		leftActivations := activations[TypeCameraLeft]
+		rightActivations := activations[TypeCameraRight]
 
 		query := NewMatrix(3*Outputs, 1)
 		query.Data = query.Data[:cap(query.Data)]
@@ -455,26 +775,41 @@ func main() {
 		out := NewNet(4, 3*Outputs, 32)
 		out.N = 4
 		out.Length = 4 * 4 * 4
-		go centerProcessor.Process(centerActivations)
-		go leftProcessor.Process(leftActivations)
-		go rightProcessor.Process(rightActivations)
-		go func() {
-			for running {
-				select {
-				case frame := <-center.Images:
-					fmt.Println("center", frame.Frame.Bounds())
-					centerProcessor.Input <- Convert(1, frame.Frame)
-				case frame := <-left.Images:
-					fmt.Println("left", frame.Frame.Bounds())
-					leftProcessor.Input <- Convert(2, frame.Frame)
-				case frame := <-right.Images:
-					fmt.Println("right", frame.Frame.Bounds())
-					rightProcessor.Input <- Convert(3, frame.Frame)
+		for i, camera := range cameras {
+			go processors[i].Process(activations[i])
+			go func(source uint32, camera Camera, processor *FrameProcessor) {
+				for frame := range camera.Frames() {
+					if stereo != nil && TypeCamera(source-1) != TypeCameraCenter {
+						stereo.Frame(frame, TypeCamera(source-1) == TypeCameraLeft)
+					}
+					processor.Input <- Convert(source, frame.Frame)
 				}
-			}
-		}()
-		for running {
+			}(uint32(i+1), camera, processors[i])
+		}
+		report := time.NewTicker(SupervisorReport)
+		defer report.Stop()
+		var turn *Turn
+		for {
 			select {
+			case <-ctx.Done():
+				return
+			case <-report.C:
+				stale := 0
+				for _, camera := range cameras {
+					fmt.Println(camera.Health())
+					if camera.Stale() {
+						stale++
+					}
+				}
+				// without any camera there is nothing to decide with
+				mutex.Lock()
+				if stale == len(cameras) && mode == ModeAuto {
+					fmt.Println("no camera is streaming, stopping")
+					tracks = Tracks{}
+					update()
+				}
+				mutex.Unlock()
+				continue
 			case frame := <-centerActivations:
 				copy(query.Data[:Outputs], frame.Query.Data)
 				copy(key.Data[:Outputs], frame.Key.Data)
@@ -488,6 +823,17 @@ func main() {
 				copy(key.Data[2*Outputs:3*Outputs], frame.Key.Data)
 				copy(value.Data[2*Outputs:3*Outputs], frame.Value.Data)
 			}
+			// the inputs of a camera that is not streaming are stale
+			for i, camera := range cameras {
+				if camera.Stale() {
+					segment := i * Outputs
+					for _, data := range [][]float32{query.Data, key.Data, value.Data} {
+						for j := segment; j < segment+Outputs; j++ {
+							data[j] = 0
+						}
+					}
+				}
+			}
 			var votes [5]int
 			_, q, k, v := out.Fire(query, key, value)
 			votes[near(actionsQ, q.Data)]++
@@ -504,32 +850,71 @@ func main() {
 			}
 			fmt.Println("...............................................................................")
 			fmt.Println("index=", index)
+			if hardware.Odometry != nil {
+				pose, velocity := hardware.Odometry.Pose(), hardware.Odometry.Velocity()
+				fmt.Printf("pose x=%.3f y=%.3f heading=%.1f velocity left=%.3f right=%.3f\n",
+					pose.X, pose.Y, pose.Heading*180/math.Pi, velocity.Left, velocity.Right)
+			}
+			if hardware.Battery != nil {
+				fmt.Println(hardware.Battery)
+			}
+			if hardware.IMU != nil {
+				fmt.Printf("imu heading=%.1f tilt=%.1f\n", hardware.IMU.Heading(), hardware.IMU.Tilt())
+			}
+			mutex.Lock()
+			if mode != ModeAuto {
+				turn = nil
+			}
+			if turn != nil {
+				// keep turning until the imu says the turn is done
+				if !turn.Done(hardware.IMU.Heading()) {
+					update()
+					mutex.Unlock()
+					continue
+				}
+				turn = nil
+			}
 			if mode == ModeAuto {
-				switch index {
-				case 0:
-					joystickLeft = JoystickStateUp
-					joystickRight = JoystickStateUp
-				case 1:
-					joystickLeft = JoystickStateDown
-					joystickRight = JoystickStateUp
-				case 2:
-					joystickLeft = JoystickStateUp
-					joystickRight = JoystickStateDown
-				case 3:
-					joystickLeft = JoystickStateNone
-					joystickRight = JoystickStateNone
-				case 4:
-					joystickLeft = JoystickStateDown
-					joystickRight = JoystickStateDown
+				if hardware.Rangefinder != nil {
+					distance, ok := hardware.Rangefinder.Distance()
+					if !ok {
+						// without a recent reading assume an obstacle
+						distance = 0
+					}
+					if action := Veto(Action(index), profile.Actions[index], distance, profile.Ultrasonic.Threshold); action != Action(index) {
+						fmt.Printf("veto action %d, obstacle at %.2f m\n", index, distance)
+						index = int(action)
+					}
+				}
+				if stereo != nil {
+					distance, ok := stereo.Nearest()
+					if !ok {
+						// without a recent depth assume an obstacle
+						distance = 0
+					}
+					if action := Veto(Action(index), profile.Actions[index], distance, profile.Stereo.Threshold); action != Action(index) {
+						fmt.Printf("veto action %d, stereo obstacle at %.2f m\n", index, distance)
+						index = int(action)
+					}
+				}
+				tracks = profile.Actions[index]
+				if hardware.IMU != nil && profile.IMU.TurnAngle > 0 {
+					timeout := time.Duration(profile.IMU.TurnTimeout) * time.Millisecond
+					switch Action(index) {
+					case ActionLeft:
+						turn = NewTurn(hardware.IMU.Heading(), profile.IMU.TurnAngle, timeout)
+					case ActionRight:
+						turn = NewTurn(hardware.IMU.Heading(), -profile.IMU.TurnAngle, timeout)
+					}
 				}
 				update()
 			}
+			mutex.Unlock()
 		}
 	}()
 
-	pwmUpDownServo := 1500
-	pwmLeftRightServo := 1500
 	for running {
+		mutex.Lock()
 		for event = sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
 			switch t := event.(type) {
 			case *sdl.QuitEvent:
@@ -537,39 +922,13 @@ func main() {
 			case *sdl.JoyAxisEvent:
 				value := int16(t.Value)
 				axis[t.Axis] = value
-				if t.Axis == 3 || t.Axis == 4 {
+				if t.Axis != AxisTrigger {
 					if mode == ModeManual {
-						if axis[3] < 20000 && axis[3] > -20000 {
-							if axis[4] < -32000 {
-								joystickRight = JoystickStateUp
-							} else if axis[4] > 32000 {
-								joystickRight = JoystickStateDown
-							} else {
-								joystickRight = JoystickStateNone
-							}
-						} else {
-							joystickRight = JoystickStateNone
-						}
-					}
-					//fmt.Printf("right [%d ms] Which: %v \t%d %d\n",
-					//		t.Timestamp, t.Which, axis[3], axis[4])
-				} else if t.Axis == 0 || t.Axis == 1 {
-					if mode == ModeManual {
-						if axis[0] < 20000 && axis[0] > -20000 {
-							if axis[1] < -32000 {
-								joystickLeft = JoystickStateUp
-							} else if axis[1] > 32000 {
-								joystickLeft = JoystickStateDown
-							} else {
-								joystickLeft = JoystickStateNone
-							}
-						} else {
-							joystickLeft = JoystickStateNone
-						}
+						tracks = profile.Joystick.Tracks(axis)
 					}
-					//fmt.Printf("left [%d ms] Which: %v \t%d %d\n",
-					//t.Timestamp, t.Which, axis[0], axis[1])
-				} else if t.Axis == 2 {
+					//fmt.Printf("[%d ms] Which: %v \t%d %d %d %d\n",
+					//	t.Timestamp, t.Which, axis[0], axis[1], axis[3], axis[4])
+				} else {
 					//fmt.Printf("2 axis [%d ms] Which: %v \t%x\n",
 					//	t.Timestamp, t.Which, value)
 					speed = axis[2]
@@ -584,66 +943,51 @@ func main() {
 			case *sdl.JoyButtonEvent:
 				fmt.Printf("[%d ms] Button:%d\tstate:%d\n",
 					t.Timestamp, t.Button, t.State)
-				if t.Button == 0 && t.State == 1 {
+				if int(t.Button) == profile.Watchdog.EStop && t.State == 1 {
+					hardware.Watchdog.EStop()
+					mode = ModeManual
+					tracks = Tracks{}
+					fmt.Println("emergency stop")
+				} else if int(t.Button) == profile.Watchdog.Clear && t.State == 1 {
+					hardware.Watchdog.Clear()
+					fmt.Println("emergency stop cleared")
+				} else if t.Button == 0 && t.State == 1 {
 					switch mode {
 					case ModeManual:
 						mode = ModeAuto
 					case ModeAuto:
 						mode = ModeManual
-						joystickLeft = JoystickStateNone
-						joystickRight = JoystickStateNone
+						tracks = Tracks{}
 						update()
 					}
 				} else if t.Button == 1 && t.State == 1 {
 					pwm = (pwm + 25) % 100
+				} else if (t.Button == 4 || t.Button == 5) && t.State == 1 {
+					// nudge the robot to the left or the right when driving straight
+					left, right := hardware.L298N.Trim()
+					if t.Button == 4 {
+						left, right = Balance(left, right, TrimStep)
+					} else {
+						left, right = Balance(left, right, -TrimStep)
+					}
+					hardware.L298N.SetTrim(left, right)
+					fmt.Printf("trim left %.2f right %.2f\n", left, right)
 				}
 			case *sdl.JoyHatEvent:
 				fmt.Printf("[%d ms] Hat:%d\tvalue:%d\n",
 					t.Timestamp, t.Hat, t.Value)
 				if t.Value == 1 {
 					// up
-					if pwmUpDownServo < 2500 {
-						pwmUpDownServo += 100
-						servoUpDown.SetValue(1)
-						done := time.After(time.Duration(pwmUpDownServo) * time.Microsecond)
-						go func() {
-							<-done
-							servoUpDown.SetValue(0)
-						}()
-					}
+					servoUpDown.SetAngle(servoUpDown.TargetAngle() + ServoStep)
 				} else if t.Value == 4 {
 					// down
-					if pwmUpDownServo > 100 {
-						pwmUpDownServo -= 100
-						servoUpDown.SetValue(1)
-						done := time.After(time.Duration(pwmUpDownServo) * time.Microsecond)
-						go func() {
-							<-done
-							servoUpDown.SetValue(0)
-						}()
-					}
+					servoUpDown.SetAngle(servoUpDown.TargetAngle() - ServoStep)
 				} else if t.Value == 8 {
 					// left
-					if pwmLeftRightServo < 2500 {
-						pwmLeftRightServo += 100
-						servoLeftRight.SetValue(1)
-						done := time.After(time.Duration(pwmLeftRightServo) * time.Microsecond)
-						go func() {
-							<-done
-							servoLeftRight.SetValue(0)
-						}()
-					}
+					servoLeftRight.SetAngle(servoLeftRight.TargetAngle() + ServoStep)
 				} else if t.Value == 2 {
 					// right
-					if pwmLeftRightServo > 100 {
-						pwmLeftRightServo -= 100
-						servoLeftRight.SetValue(1)
-						done := time.After(time.Duration(pwmLeftRightServo) * time.Microsecond)
-						go func() {
-							<-done
-							servoLeftRight.SetValue(0)
-						}()
-					}
+					servoLeftRight.SetAngle(servoLeftRight.TargetAngle() - ServoStep)
 				}
 			case *sdl.JoyDeviceAddedEvent:
 				fmt.Println(t.Which)
@@ -655,12 +999,67 @@ func main() {
 				if joystick := joysticks[int(t.Which)]; joystick != nil {
 					joystick.Close()
 				}
+				delete(joysticks, int(t.Which))
 				fmt.Printf("Joystick %d disconnected\n", t.Which)
+				mode = ModeManual
+				tracks = Tracks{}
+				drive.Stop()
 			default:
 				fmt.Printf("Unknown event\n")
 			}
 		}
 
+		select {
+		case s := <-signals:
+			fmt.Printf("%v, shutting down\n", s)
+			running = false
+		default:
+		}
+
+		// a critical battery stops the motors and keeps the robot out of auto mode
+		if hardware.Battery != nil && hardware.Battery.Level() == BatteryCritical && mode == ModeAuto {
+			fmt.Println(hardware.Battery, "leaving auto mode")
+			mode = ModeManual
+			tracks = Tracks{}
+			drive.Stop()
+		}
+
+		// the watchdog stops the tracks if this loop stalls or the joystick is unplugged
+		if Repeat(mode, devices()...) {
+			update()
+		}
+		mutex.Unlock()
+
 		sdl.Delay(16)
 	}
+
+	// stop deciding and then stop the tracks before anything else can go wrong,
+	// so that auto mode cannot drive the tracks again
+	steps := []func() error{func() error {
+		cancel()
+		<-decided
+		mutex.Lock()
+		defer mutex.Unlock()
+		mode, tracks = ModeManual, Tracks{}
+		return drive.Stop()
+	}}
+	for _, camera := range cameras {
+		steps = append(steps, camera.Stop)
+	}
+	steps = append(steps, hardware.Close, func() error {
+		for which, joystick := range joysticks {
+			if joystick != nil {
+				joystick.Close()
+			}
+			delete(joysticks, which)
+		}
+		return nil
+	})
+	err = Shutdown(ShutdownTimeout, func() {
+		fmt.Printf("shutdown took longer than %v\n", ShutdownTimeout)
+		os.Exit(1)
+	}, steps...)
+	if err != nil {
+		fmt.Println(err)
+	}
 }
@@ -0,0 +1,180 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	// PCA9685Address is the default i2c address of a PCA9685
+	PCA9685Address = 0x40
+	// PCA9685Frequency is the pwm frequency used for both servos and motor enables
+	PCA9685Frequency = 50
+	// PCA9685Channels is the number of pwm channels
+	PCA9685Channels = 16
+)
+
+const (
+	pca9685Mode1    = 0x00
+	pca9685Mode2    = 0x01
+	pca9685Led0     = 0x06
+	pca9685AllLed   = 0xFA
+	pca9685PreScale = 0xFE
+
+	pca9685Restart = 0x80
+	pca9685AI      = 0x20
+	pca9685Sleep   = 0x10
+	pca9685AllCall = 0x01
+	pca9685OutDrv  = 0x04
+	pca9685Full    = 0x10
+
+	// pca9685Oscillator is the frequency of the internal oscillator
+	pca9685Oscillator = 25000000
+	// pca9685Steps is the resolution of a pwm period
+	pca9685Steps = 4096
+)
+
+// PCA9685 is a 16 channel 12 bit i2c pwm controller
+type PCA9685 struct {
+	Device    I2C
+	Frequency float64
+}
+
+// NewPCA9685 resets a PCA9685 and sets its pwm frequency
+func NewPCA9685(device I2C, frequency float64) (*PCA9685, error) {
+	p := &PCA9685{
+		Device: device,
+	}
+	err := p.allOff()
+	if err != nil {
+		return nil, err
+	}
+	err = device.WriteReg(pca9685Mode2, pca9685OutDrv)
+	if err != nil {
+		return nil, err
+	}
+	err = device.WriteReg(pca9685Mode1, pca9685AllCall)
+	if err != nil {
+		return nil, err
+	}
+	// the oscillator takes 500us to start after leaving sleep
+	time.Sleep(time.Millisecond)
+	err = p.SetFrequency(frequency)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// SetFrequency sets the pwm frequency of all channels, from 24 Hz to 1526 Hz
+func (p *PCA9685) SetFrequency(frequency float64) error {
+	prescale := math.Round(pca9685Oscillator/(pca9685Steps*frequency)) - 1
+	if prescale < 3 || prescale > 255 {
+		return fmt.Errorf("pca9685 frequency %v Hz is out of range", frequency)
+	}
+	var mode [1]byte
+	err := p.Device.ReadReg(pca9685Mode1, mode[:])
+	if err != nil {
+		return err
+	}
+	old := mode[0] &^ pca9685Restart
+	// the prescaler can only be set while sleeping
+	err = p.Device.WriteReg(pca9685Mode1, old|pca9685Sleep)
+	if err != nil {
+		return err
+	}
+	err = p.Device.WriteReg(pca9685PreScale, byte(prescale))
+	if err != nil {
+		return err
+	}
+	err = p.Device.WriteReg(pca9685Mode1, old&^pca9685Sleep)
+	if err != nil {
+		return err
+	}
+	time.Sleep(time.Millisecond)
+	err = p.Device.WriteReg(pca9685Mode1, (old&^pca9685Sleep)|pca9685Restart|pca9685AI)
+	if err != nil {
+		return err
+	}
+	p.Frequency = pca9685Oscillator / (pca9685Steps * (prescale + 1))
+	return nil
+}
+
+// Period returns the actual pwm period
+func (p *PCA9685) Period() time.Duration {
+	return time.Duration(float64(time.Second) / p.Frequency)
+}
+
+// registers computes the on and off registers of a channel for a duty cycle
+func (p *PCA9685) registers(duty float64) []byte {
+	duty = clampDuty(duty)
+	if duty == 0 {
+		return []byte{0, 0, 0, pca9685Full}
+	} else if duty == 1 {
+		return []byte{0, pca9685Full, 0, 0}
+	}
+	off := int(math.Round(duty * pca9685Steps))
+	if off >= pca9685Steps {
+		off = pca9685Steps - 1
+	}
+	return []byte{0, 0, byte(off), byte(off >> 8)}
+}
+
+// SetDuty sets the duty cycle of a channel
+func (p *PCA9685) SetDuty(channel int, duty float64) error {
+	if channel < 0 || channel >= PCA9685Channels {
+		return fmt.Errorf("pca9685 channel %d is out of range", channel)
+	}
+	return p.Device.WriteReg(byte(pca9685Led0+4*channel), p.registers(duty)...)
+}
+
+// SetPulse sets the high time of each period of a channel
+func (p *PCA9685) SetPulse(channel int, pulse time.Duration) error {
+	return p.SetDuty(channel, float64(pulse)/float64(p.Period()))
+}
+
+// allOff turns off every channel
+func (p *PCA9685) allOff() error {
+	return p.Device.WriteReg(pca9685AllLed, 0, 0, 0, pca9685Full)
+}
+
+// Channel returns a pwm output for a channel
+func (p *PCA9685) Channel(channel int) *PCA9685Channel {
+	return &PCA9685Channel{
+		Chip:    p,
+		Channel: channel,
+	}
+}
+
+// Close turns off every channel, puts the chip to sleep and releases the device
+func (p *PCA9685) Close() error {
+	err := p.allOff()
+	if e := p.Device.WriteReg(pca9685Mode1, pca9685Sleep); err == nil {
+		err = e
+	}
+	if e := p.Device.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// PCA9685Channel is a pwm output on one channel of a PCA9685
+type PCA9685Channel struct {
+	Chip    *PCA9685
+	Channel int
+}
+
+// SetDuty sets the duty cycle of the channel
+func (p *PCA9685Channel) SetDuty(duty float64) error {
+	return p.Chip.SetDuty(p.Channel, duty)
+}
+
+// Close turns the channel off, the chip is released by PCA9685.Close
+func (p *PCA9685Channel) Close() error {
+	return p.Chip.SetDuty(p.Channel, 0)
+}
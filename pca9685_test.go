@@ -0,0 +1,145 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPCA9685Setup(t *testing.T) {
+	tests := []struct {
+		frequency float64
+		prescale  byte
+	}{
+		{50, 121},
+		{60, 101},
+		{1000, 5},
+		{24, 253},
+	}
+	for _, test := range tests {
+		device := NewSimI2C()
+		p, err := NewPCA9685(device, test.frequency)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if prescale := device.Registers[pca9685PreScale]; prescale != test.prescale {
+			t.Fatalf("%v Hz prescale is %d, want %d", test.frequency, prescale, test.prescale)
+		}
+		if math.Abs(p.Frequency-test.frequency)/test.frequency > .05 {
+			t.Fatalf("%v Hz runs at %v Hz", test.frequency, p.Frequency)
+		}
+		// the prescaler is only written while the oscillator sleeps
+		sleeping := false
+		for _, write := range device.Writes {
+			switch write.Reg {
+			case pca9685Mode1:
+				sleeping = write.Data[0]&pca9685Sleep != 0
+			case pca9685PreScale:
+				if !sleeping {
+					t.Fatal("the prescaler was written while the oscillator was running")
+				}
+			}
+		}
+		mode1 := device.Registers[pca9685Mode1]
+		if mode1&pca9685AI == 0 || mode1&pca9685Sleep != 0 {
+			t.Fatalf("mode1 is 0x%02x, want auto increment and awake", mode1)
+		}
+		if device.Registers[pca9685Mode2] != pca9685OutDrv {
+			t.Fatalf("mode2 is 0x%02x, want totem pole outputs", device.Registers[pca9685Mode2])
+		}
+	}
+	for _, frequency := range []float64{10, 2000} {
+		if _, err := NewPCA9685(NewSimI2C(), frequency); err == nil {
+			t.Fatalf("%v Hz was accepted", frequency)
+		}
+	}
+}
+
+func TestPCA9685Duty(t *testing.T) {
+	device := NewSimI2C()
+	p, err := NewPCA9685(device, PCA9685Frequency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		duty      float64
+		registers []byte
+	}{
+		// full off is bit 4 of the off high byte
+		{0, []byte{0, 0, 0, pca9685Full}},
+		{-1, []byte{0, 0, 0, pca9685Full}},
+		// full on is bit 4 of the on high byte
+		{1, []byte{0, pca9685Full, 0, 0}},
+		{2, []byte{0, pca9685Full, 0, 0}},
+		{.5, []byte{0, 0, 0, 8}},
+		{.25, []byte{0, 0, 0, 4}},
+		{.9999, []byte{0, 0, 0xff, 0x0f}},
+	}
+	for _, test := range tests {
+		for _, channel := range []int{0, 2, 15} {
+			device.Writes = nil
+			if err := p.Channel(channel).SetDuty(test.duty); err != nil {
+				t.Fatal(err)
+			}
+			// the four registers of a channel are written at once with auto increment
+			if len(device.Writes) != 1 || device.Writes[0].Reg != byte(pca9685Led0+4*channel) {
+				t.Fatalf("channel %d writes %v", channel, device.Writes)
+			}
+			base := pca9685Led0 + 4*channel
+			if registers := device.Registers[base : base+4]; !bytes.Equal(registers, test.registers) {
+				t.Fatalf("duty %v on channel %d is %v, want %v", test.duty, channel, registers, test.registers)
+			}
+		}
+	}
+	if err := p.SetDuty(PCA9685Channels, .5); err == nil {
+		t.Fatal("an out of range channel was accepted")
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(device.Registers[pca9685AllLed:pca9685AllLed+4], []byte{0, 0, 0, pca9685Full}) {
+		t.Fatal("the channels were not all turned off")
+	}
+	if device.Registers[pca9685Mode1]&pca9685Sleep == 0 || !device.Closed {
+		t.Fatal("the chip was not put to sleep and released")
+	}
+}
+
+func TestPCA9685Servo(t *testing.T) {
+	for _, frequency := range []float64{50, 60, 200} {
+		device := NewSimI2C()
+		p, err := NewPCA9685(device, frequency)
+		if err != nil {
+			t.Fatal(err)
+		}
+		servo := NewServo(p.Channel(2), p.Period(), DefaultServoLimits)
+		base := pca9685Led0 + 4*2
+		registers := device.Registers[base : base+4]
+		off := int(registers[2]) | int(registers[3])<<8
+		// the pulse is measured against the actual period of the chip
+		pulse := time.Duration(float64(off) / pca9685Steps * float64(p.Period()))
+		center := (DefaultServoLimits.Min + DefaultServoLimits.Max) / 2
+		if delta := pulse - center; delta < -5*time.Microsecond || delta > 5*time.Microsecond {
+			t.Fatalf("%v Hz center pulse is %v, want %v", frequency, pulse, center)
+		}
+		servo.Close()
+	}
+}
+
+func TestPCA9685ServoPeriod(t *testing.T) {
+	profile := DefaultProfile()
+	profile.PWM.Backend = PWMPCA9685
+	if err := profile.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	// a 2500 us pulse does not fit in the 1 ms period of 1000 Hz
+	profile.PWM.Frequency = 1000
+	if err := profile.Validate(); err == nil {
+		t.Fatal("servo pulses longer than the period were accepted")
+	}
+}
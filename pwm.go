@@ -0,0 +1,202 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// PWM is a pulse width modulated output
+type PWM interface {
+	// SetDuty sets the duty cycle from 0 to 1
+	SetDuty(duty float64) error
+	// Close turns the output off and releases it
+	Close() error
+}
+
+// clampDuty limits a duty cycle to the range 0 to 1
+func clampDuty(duty float64) float64 {
+	if duty > 1 {
+		return 1
+	} else if duty < 0 || math.IsNaN(duty) {
+		return 0
+	}
+	return duty
+}
+
+// SoftPWM is a pwm output that toggles a line from a goroutine
+type SoftPWM struct {
+	Line   Line
+	Period time.Duration
+
+	mutex sync.Mutex
+	duty  float64
+	done  chan struct{}
+	wait  sync.WaitGroup
+}
+
+// NewSoftPWM creates a new soft pwm on a line
+func NewSoftPWM(line Line, period time.Duration) *SoftPWM {
+	s := &SoftPWM{
+		Line:   line,
+		Period: period,
+		done:   make(chan struct{}),
+	}
+	s.wait.Add(1)
+	go s.run()
+	return s
+}
+
+// run toggles the line, the high time of each period is the duty cycle
+func (s *SoftPWM) run() {
+	defer s.wait.Done()
+	sleep := func(d time.Duration) bool {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-s.done:
+			return false
+		case <-timer.C:
+			return true
+		}
+	}
+	for {
+		s.mutex.Lock()
+		high := time.Duration(s.duty * float64(s.Period))
+		s.mutex.Unlock()
+		if high > 0 {
+			s.Line.SetValue(1)
+			if !sleep(high) {
+				return
+			}
+		}
+		if high < s.Period {
+			s.Line.SetValue(0)
+			if !sleep(s.Period - high) {
+				return
+			}
+		}
+	}
+}
+
+// SetDuty sets the duty cycle
+func (s *SoftPWM) SetDuty(duty float64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.duty = clampDuty(duty)
+	return nil
+}
+
+// Close stops the goroutine, sets the line low and releases it
+func (s *SoftPWM) Close() error {
+	close(s.done)
+	s.wait.Wait()
+	err := s.Line.SetValue(0)
+	if e := s.Line.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// SysfsPWMRoot is the default location of the sysfs pwm class
+const SysfsPWMRoot = "/sys/class/pwm"
+
+// SysfsPWM is a hardware pwm channel controlled through sysfs
+type SysfsPWM struct {
+	// Chip is the pwmchip directory
+	Chip string
+	// Channel is the pwm channel of the chip
+	Channel int
+	// Period is the period of the pwm
+	Period time.Duration
+}
+
+// NewSysfsPWM exports and enables a channel of the pwm chip found under root
+func NewSysfsPWM(root string, chip, channel int, period time.Duration) (*SysfsPWM, error) {
+	if period <= 0 {
+		return nil, fmt.Errorf("pwm period %v must be positive", period)
+	}
+	s := &SysfsPWM{
+		Chip:    filepath.Join(root, fmt.Sprintf("pwmchip%d", chip)),
+		Channel: channel,
+		Period:  period,
+	}
+	if _, err := os.Stat(s.Chip); err != nil {
+		return nil, fmt.Errorf("pwm chip %d not found: %w", chip, err)
+	}
+	if _, err := os.Stat(s.path()); errors.Is(err, os.ErrNotExist) {
+		err = s.write(filepath.Join(s.Chip, "export"), int64(channel))
+		if err != nil {
+			return nil, err
+		}
+	}
+	// the channel directory and its permissions show up asynchronously after an export
+	var err error
+	for i := 0; i < 20; i++ {
+		if err = s.write(s.attribute("duty_cycle"), 0); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = s.write(s.attribute("period"), period.Nanoseconds())
+	if err != nil {
+		return nil, err
+	}
+	err = s.write(s.attribute("enable"), 1)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// path is the directory of the channel
+func (s *SysfsPWM) path() string {
+	return filepath.Join(s.Chip, fmt.Sprintf("pwm%d", s.Channel))
+}
+
+// attribute is the path of a channel attribute
+func (s *SysfsPWM) attribute(name string) string {
+	return filepath.Join(s.path(), name)
+}
+
+// write writes an integer to a sysfs file
+func (s *SysfsPWM) write(name string, value int64) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(strconv.FormatInt(value, 10))
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// SetDuty sets the duty cycle
+func (s *SysfsPWM) SetDuty(duty float64) error {
+	return s.write(s.attribute("duty_cycle"), int64(clampDuty(duty)*float64(s.Period.Nanoseconds())))
+}
+
+// Close disables and unexports the channel
+func (s *SysfsPWM) Close() error {
+	err := s.write(s.attribute("duty_cycle"), 0)
+	if e := s.write(s.attribute("enable"), 0); err == nil {
+		err = e
+	}
+	if e := s.write(filepath.Join(s.Chip, "unexport"), int64(s.Channel)); err == nil {
+		err = e
+	}
+	return err
+}
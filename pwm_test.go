@@ -0,0 +1,147 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSysfsPWM creates a pwm chip under root that makes a channel directory when the channel is exported,
+// like the kernel the directory shows up after the export returns
+func fakeSysfsPWM(t *testing.T, root string, chip string) {
+	t.Helper()
+	dir := filepath.Join(root, chip)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"export", "unexport"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		close(done)
+	})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+			data, err := os.ReadFile(filepath.Join(dir, "export"))
+			if err != nil || len(data) == 0 {
+				continue
+			}
+			channel := filepath.Join(dir, "pwm"+string(data))
+			os.MkdirAll(channel, 0755)
+			for _, name := range []string{"period", "duty_cycle", "enable"} {
+				os.WriteFile(filepath.Join(channel, name), nil, 0644)
+			}
+			return
+		}
+	}()
+}
+
+// readSysfs reads a sysfs attribute
+func readSysfs(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func TestSysfsPWM(t *testing.T) {
+	root := t.TempDir()
+	fakeSysfsPWM(t, root, "pwmchip0")
+	pwm, err := NewSysfsPWM(root, 0, 1, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chip := filepath.Join(root, "pwmchip0")
+	channel := filepath.Join(chip, "pwm1")
+	for name, want := range map[string]string{
+		filepath.Join(chip, "export"):        "1",
+		filepath.Join(channel, "period"):     "1000000",
+		filepath.Join(channel, "duty_cycle"): "0",
+		filepath.Join(channel, "enable"):     "1",
+	} {
+		if got := readSysfs(t, name); got != want {
+			t.Fatalf("%s is %s, want %s", name, got, want)
+		}
+	}
+	for duty, want := range map[float64]string{.25: "250000", 1: "1000000", 2: "1000000", -1: "0"} {
+		if err := pwm.SetDuty(duty); err != nil {
+			t.Fatal(err)
+		}
+		if got := readSysfs(t, filepath.Join(channel, "duty_cycle")); got != want {
+			t.Fatalf("duty %v is %s, want %s", duty, got, want)
+		}
+	}
+	if err := pwm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		filepath.Join(channel, "duty_cycle"): "0",
+		filepath.Join(channel, "enable"):     "0",
+		filepath.Join(chip, "unexport"):      "1",
+	} {
+		if got := readSysfs(t, name); got != want {
+			t.Fatalf("%s is %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestSysfsPWMMissing(t *testing.T) {
+	if _, err := NewSysfsPWM(t.TempDir(), 0, 0, time.Millisecond); err == nil {
+		t.Fatal("a missing chip was opened")
+	}
+	if _, err := NewSysfsPWM(t.TempDir(), 0, 0, 0); err == nil {
+		t.Fatal("a zero period was accepted")
+	}
+}
+
+func TestSysfsPWMFallback(t *testing.T) {
+	if _, err := os.Stat(filepath.Join(SysfsPWMRoot, "pwmchip0")); err == nil {
+		t.Skip("this machine has a pwm chip")
+	}
+	profile := DefaultProfile()
+	profile.Chip = ChipSim
+	profile.PWM.Backend = PWMSysfs
+	h, err := OpenHardware(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if _, ok := h.L298N.Left.Enable.(*SoftPWM); !ok {
+		t.Fatalf("the left enable is a %T", h.L298N.Left.Enable)
+	}
+}
+
+func TestSysfsEnableLines(t *testing.T) {
+	profile := DefaultProfile()
+	profile.PWM.Backend = PWMSysfs
+	if err := profile.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	// the fallback claims the enable line, so it can not be shared
+	profile.Left.Enable = profile.Tilt.Line
+	if err := profile.Validate(); err == nil {
+		t.Fatal("an enable line used by a servo was accepted")
+	}
+	profile = DefaultProfile()
+	profile.PWM.Backend = PWMSysfs
+	profile.Right.Channel = profile.Left.Channel
+	if err := profile.Validate(); err == nil {
+		t.Fatal("a shared enable channel was accepted")
+	}
+}
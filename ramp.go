@@ -0,0 +1,160 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// RampPeriod is the period at which track speeds are stepped towards their targets
+const RampPeriod = 20 * time.Millisecond
+
+// RampLimits are the limits on how fast the speed of a track can change
+type RampLimits struct {
+	// Acceleration is the largest increase in speed per second, zero is unlimited
+	Acceleration float64
+	// Deceleration is the largest decrease in speed per second, zero is unlimited
+	Deceleration float64
+	// Coast is how long a track rests at zero before reversing
+	Coast time.Duration
+}
+
+// ramp is the ramping state of one track
+type ramp struct {
+	target  float64
+	current float64
+	// direction is the sign of the last nonzero speed
+	direction float64
+	// stopped is when the track last came to rest
+	stopped time.Time
+}
+
+// step moves the track speed towards the target, dt is the time since the last step
+func (r *ramp) step(limits RampLimits, now time.Time, dt time.Duration) {
+	move := func(to, rate float64) {
+		if rate <= 0 {
+			r.current = to
+			return
+		}
+		delta, max := to-r.current, rate*dt.Seconds()
+		if math.Abs(delta) > max {
+			delta = math.Copysign(max, delta)
+		}
+		r.current += delta
+	}
+	switch {
+	case r.current != 0 && math.Signbit(r.current) != math.Signbit(r.target) && r.target != 0:
+		// slow down before reversing
+		move(0, limits.Deceleration)
+	case r.current == 0 && r.target != 0 && r.direction != 0 &&
+		math.Signbit(r.target) != math.Signbit(r.direction) &&
+		now.Sub(r.stopped) < limits.Coast:
+		// coast before reversing
+	case math.Abs(r.target) > math.Abs(r.current):
+		move(r.target, limits.Acceleration)
+	default:
+		move(r.target, limits.Deceleration)
+	}
+	if r.current != 0 {
+		r.direction = math.Copysign(1, r.current)
+		r.stopped = time.Time{}
+	} else if r.direction != 0 && r.stopped.IsZero() {
+		r.stopped = now
+	}
+}
+
+// halt immediately brings the track to rest
+func (r *ramp) halt(now time.Time) {
+	r.target, r.current = 0, 0
+	if r.direction != 0 {
+		r.stopped = now
+	}
+}
+
+// RampDrive is a differential drive that limits the acceleration of another drive
+type RampDrive struct {
+	Drive  DifferentialDrive
+	Limits RampLimits
+
+	mutex sync.Mutex
+	left  ramp
+	right ramp
+	done  chan struct{}
+	wait  sync.WaitGroup
+}
+
+// NewRampDrive creates a new ramping drive on top of drive
+func NewRampDrive(drive DifferentialDrive, limits RampLimits) *RampDrive {
+	r := &RampDrive{
+		Drive:  drive,
+		Limits: limits,
+		done:   make(chan struct{}),
+	}
+	r.wait.Add(1)
+	go r.run()
+	return r
+}
+
+// run steps the tracks towards their targets every RampPeriod
+func (r *RampDrive) run() {
+	defer r.wait.Done()
+	t := time.NewTicker(RampPeriod)
+	defer t.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-r.done:
+			return
+		case now := <-t.C:
+			r.mutex.Lock()
+			dt := now.Sub(last)
+			last = now
+			left, right := r.left.current, r.right.current
+			r.left.step(r.Limits, now, dt)
+			r.right.step(r.Limits, now, dt)
+			if r.left.current != left || r.right.current != right {
+				r.Drive.SetTracks(r.left.current, r.right.current)
+			}
+			r.mutex.Unlock()
+		}
+	}
+}
+
+// SetTracks sets the target speeds of the tracks, the speeds ramp towards them
+func (r *RampDrive) SetTracks(left, right float64) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.left.target, r.right.target = clamp(left), clamp(right)
+	return nil
+}
+
+// Current returns the current ramped track speeds
+func (r *RampDrive) Current() Tracks {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return Tracks{
+		Left:  r.left.current,
+		Right: r.right.current,
+	}
+}
+
+// Stop immediately cuts power to both tracks so that they coast to a stop
+func (r *RampDrive) Stop() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	now := time.Now()
+	r.left.halt(now)
+	r.right.halt(now)
+	return r.Drive.Stop()
+}
+
+// Close stops both tracks and closes the underlying drive
+func (r *RampDrive) Close() error {
+	close(r.done)
+	r.wait.Wait()
+	return r.Drive.Close()
+}
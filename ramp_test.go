@@ -0,0 +1,154 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// testRampLimits accelerate by .04 and decelerate by .08 per RampPeriod and coast for 5 periods
+var testRampLimits = RampLimits{
+	Acceleration: 2,
+	Deceleration: 4,
+	Coast:        5 * RampPeriod,
+}
+
+func TestRampStep(t *testing.T) {
+	tests := []struct {
+		name    string
+		current float64
+		target  float64
+		want    float64
+	}{
+		{"accelerate", 0, 1, .04},
+		{"accelerate backward", 0, -1, -.04},
+		{"decelerate", 1, 0, .92},
+		{"decelerate backward", -1, 0, -.92},
+		{"slow down", 1, .5, .92},
+		{"reach the target", .5, .52, .52},
+		{"slow down before reversing", .5, -1, .42},
+		{"stop before reversing", .05, -1, 0},
+	}
+	now := time.Now()
+	for _, test := range tests {
+		r := ramp{
+			current: test.current,
+			target:  test.target,
+		}
+		r.step(testRampLimits, now, RampPeriod)
+		if math.Abs(r.current-test.want) > 1e-9 {
+			t.Fatalf("%s: the speed stepped from %v to %v, want %v", test.name, test.current, r.current, test.want)
+		}
+	}
+}
+
+func TestRampUnlimited(t *testing.T) {
+	r := ramp{
+		target: -1,
+	}
+	r.step(RampLimits{}, time.Now(), RampPeriod)
+	if r.current != -1 {
+		t.Fatalf("the speed is %v without limits, want -1", r.current)
+	}
+}
+
+func TestRampReverse(t *testing.T) {
+	r := ramp{
+		current: .2,
+		target:  -.2,
+	}
+	now := time.Now()
+	var speeds []float64
+	for i := 0; i < 20; i++ {
+		now = now.Add(RampPeriod)
+		r.step(testRampLimits, now, RampPeriod)
+		speeds = append(speeds, r.current)
+	}
+	// .12, .04 and 0, then a rest for the coast of 5 periods before -.04 and on to the target
+	resting := 0
+	for i, speed := range speeds {
+		if i > 0 && speeds[i-1] > 0 && speed < 0 {
+			t.Fatalf("the track reversed without stopping: %v", speeds)
+		}
+		if i > 0 && math.Abs(speed-speeds[i-1]) > .08+1e-9 {
+			t.Fatalf("the speed changed by more than the deceleration: %v", speeds)
+		}
+		if speed == 0 {
+			resting++
+		}
+	}
+	if resting != 5 {
+		t.Fatalf("the track rested for %d periods, want 5: %v", resting, speeds)
+	}
+	if math.Abs(speeds[len(speeds)-1]+.2) > 1e-9 {
+		t.Fatalf("the track did not reach the target: %v", speeds)
+	}
+}
+
+func TestRampDrive(t *testing.T) {
+	fake := NewFakeDrive()
+	drive := NewRampDrive(fake, testRampLimits)
+	defer drive.Close()
+	drive.SetTracks(1, -1)
+	time.Sleep(5 * RampPeriod)
+	tracks := fake.Current()
+	if tracks.Left <= 0 || tracks.Left >= 1 || tracks.Right >= 0 || tracks.Right <= -1 {
+		t.Fatalf("the tracks jumped to %v", tracks)
+	}
+	if current := drive.Current(); current != tracks {
+		t.Fatalf("the ramp is at %v and the drive at %v", current, tracks)
+	}
+	fake.Lock()
+	history := append([]Tracks{}, fake.History...)
+	fake.Unlock()
+	last := Tracks{}
+	for _, tracks := range history {
+		// a late tick steps further, so allow for a couple of ticks of jitter
+		if tracks.Left < last.Left || tracks.Left-last.Left > 3*.04+1e-9 {
+			t.Fatalf("the left track stepped from %v to %v", last.Left, tracks.Left)
+		}
+		last = tracks
+	}
+}
+
+func TestRampDriveStop(t *testing.T) {
+	fake := NewFakeDrive()
+	drive := NewRampDrive(fake, testRampLimits)
+	defer drive.Close()
+	drive.SetTracks(1, 1)
+	time.Sleep(5 * RampPeriod)
+	if fake.Current() == (Tracks{}) {
+		t.Fatal("the tracks did not start")
+	}
+	// stop does not wait for the deceleration
+	if err := drive.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if tracks := fake.Current(); tracks != (Tracks{}) {
+		t.Fatalf("the tracks are at %v after a stop", tracks)
+	}
+	if current := drive.Current(); current != (Tracks{}) {
+		t.Fatalf("the ramp is at %v after a stop", current)
+	}
+	time.Sleep(3 * RampPeriod)
+	if tracks := fake.Current(); tracks != (Tracks{}) {
+		t.Fatalf("the tracks started again at %v", tracks)
+	}
+}
+
+func TestRampDriveClose(t *testing.T) {
+	fake := NewFakeDrive()
+	drive := NewRampDrive(fake, testRampLimits)
+	drive.SetTracks(1, 1)
+	time.Sleep(3 * RampPeriod)
+	if err := drive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !fake.Closed || fake.Current() != (Tracks{}) {
+		t.Fatal("the drive was not stopped and closed")
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// ServoPeriod is the period of the 50 Hz hobby servo pulse train, the pulse moves towards its target once per period
+const ServoPeriod = 20 * time.Millisecond
+
+// ServoLimits are the limits of a servo
+type ServoLimits struct {
+	// Min is the shortest pulse
+	Min time.Duration
+	// Max is the longest pulse
+	Max time.Duration
+	// Range is the travel in degrees between the shortest and longest pulse
+	Range float64
+	// Slew is the largest change in pulse width per second, zero is unlimited
+	Slew time.Duration
+	// Invert reverses the direction of travel
+	Invert bool
+}
+
+// DefaultServoLimits are the limits of a typical 180 degree hobby servo
+var DefaultServoLimits = ServoLimits{
+	Min:   500 * time.Microsecond,
+	Max:   2500 * time.Microsecond,
+	Range: 180,
+	Slew:  2000 * time.Microsecond,
+}
+
+// Servo is a hobby servo driven by a continuous pulse train
+type Servo struct {
+	PWM PWM
+	// Period is the period of the pwm, the duty cycle is the pulse width over the period
+	Period time.Duration
+	Limits ServoLimits
+
+	mutex  sync.Mutex
+	pulse  time.Duration
+	target time.Duration
+	done   chan struct{}
+	wait   sync.WaitGroup
+}
+
+// NewServo creates a new servo on a pwm with a period and centers it
+func NewServo(pwm PWM, period time.Duration, limits ServoLimits) *Servo {
+	center := (limits.Min + limits.Max) / 2
+	s := &Servo{
+		PWM:    pwm,
+		Period: period,
+		Limits: limits,
+		pulse:  center,
+		target: center,
+		done:   make(chan struct{}),
+	}
+	s.PWM.SetDuty(float64(center) / float64(period))
+	s.wait.Add(1)
+	go s.run()
+	return s
+}
+
+// run moves the pulse towards the target once per period
+func (s *Servo) run() {
+	defer s.wait.Done()
+	t := time.NewTicker(ServoPeriod)
+	defer t.Stop()
+	step := s.Limits.Slew * ServoPeriod / time.Second
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-t.C:
+		}
+		s.mutex.Lock()
+		if s.pulse == s.target {
+			s.mutex.Unlock()
+			continue
+		}
+		delta := s.target - s.pulse
+		if step > 0 && delta > step {
+			delta = step
+		} else if step > 0 && delta < -step {
+			delta = -step
+		}
+		s.pulse += delta
+		pulse := s.pulse
+		s.mutex.Unlock()
+		s.PWM.SetDuty(float64(pulse) / float64(s.Period))
+	}
+}
+
+// SetPulse sets the target pulse width, limited to the range of the servo
+func (s *Servo) SetPulse(pulse time.Duration) {
+	if pulse < s.Limits.Min {
+		pulse = s.Limits.Min
+	} else if pulse > s.Limits.Max {
+		pulse = s.Limits.Max
+	}
+	s.mutex.Lock()
+	s.target = pulse
+	s.mutex.Unlock()
+}
+
+// Pulse returns the current pulse width
+func (s *Servo) Pulse() time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.pulse
+}
+
+// Target returns the target pulse width
+func (s *Servo) Target() time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.target
+}
+
+// SetAngle sets the target angle in degrees, zero is the center
+func (s *Servo) SetAngle(angle float64) {
+	center := (s.Limits.Min + s.Limits.Max) / 2
+	scale := float64(s.Limits.Max-s.Limits.Min) / s.Limits.Range
+	if s.Limits.Invert {
+		angle = -angle
+	}
+	s.SetPulse(center + time.Duration(angle*scale))
+}
+
+// angle converts a pulse width to an angle
+func (s *Servo) angle(pulse time.Duration) float64 {
+	center := (s.Limits.Min + s.Limits.Max) / 2
+	scale := float64(s.Limits.Max-s.Limits.Min) / s.Limits.Range
+	if s.Limits.Invert {
+		return float64(center-pulse) / scale
+	}
+	return float64(pulse-center) / scale
+}
+
+// Angle returns the current angle in degrees
+func (s *Servo) Angle() float64 {
+	return s.angle(s.Pulse())
+}
+
+// TargetAngle returns the target angle in degrees
+func (s *Servo) TargetAngle() float64 {
+	return s.angle(s.Target())
+}
+
+// Close stops the pulse train and releases the pwm
+func (s *Servo) Close() error {
+	close(s.done)
+	s.wait.Wait()
+	return s.PWM.Close()
+}
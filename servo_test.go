@@ -0,0 +1,154 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePWM is a pwm that records its duty cycles
+type fakePWM struct {
+	sync.Mutex
+	Duties []float64
+	Closed bool
+}
+
+// SetDuty records a duty cycle
+func (f *fakePWM) SetDuty(duty float64) error {
+	f.Lock()
+	defer f.Unlock()
+	f.Duties = append(f.Duties, duty)
+	return nil
+}
+
+// Close marks the pwm as closed
+func (f *fakePWM) Close() error {
+	f.Lock()
+	defer f.Unlock()
+	f.Closed = true
+	return nil
+}
+
+// History returns the recorded duty cycles
+func (f *fakePWM) History() []float64 {
+	f.Lock()
+	defer f.Unlock()
+	return append([]float64{}, f.Duties...)
+}
+
+// testServo creates a servo on a fake pwm with the default limits
+func testServo(t *testing.T, limits ServoLimits) (*Servo, *fakePWM) {
+	t.Helper()
+	pwm := &fakePWM{}
+	servo := NewServo(pwm, ServoPeriod, limits)
+	t.Cleanup(func() {
+		servo.Close()
+	})
+	return servo, pwm
+}
+
+func TestServoAngle(t *testing.T) {
+	limits := DefaultServoLimits
+	limits.Slew = 0
+	servo, pwm := testServo(t, limits)
+	if duties := pwm.History(); len(duties) != 1 || math.Abs(duties[0]-1500./20000) > 1e-9 {
+		t.Fatalf("the servo was not centered, the duty cycles are %v", duties)
+	}
+	tests := []struct {
+		angle float64
+		pulse time.Duration
+		want  float64
+	}{
+		{0, 1500 * time.Microsecond, 0},
+		{45, 2000 * time.Microsecond, 45},
+		{-90, 500 * time.Microsecond, -90},
+		// past the range the angle is clamped
+		{120, 2500 * time.Microsecond, 90},
+		{-200, 500 * time.Microsecond, -90},
+	}
+	for _, test := range tests {
+		servo.SetAngle(test.angle)
+		if pulse := servo.Target(); (pulse - test.pulse).Abs() > time.Microsecond {
+			t.Fatalf("angle %v targets a %v pulse, want %v", test.angle, pulse, test.pulse)
+		}
+		if angle := servo.TargetAngle(); math.Abs(angle-test.want) > .01 {
+			t.Fatalf("angle %v targets %v degrees, want %v", test.angle, angle, test.want)
+		}
+	}
+}
+
+func TestServoInvert(t *testing.T) {
+	limits := DefaultServoLimits
+	limits.Invert = true
+	servo, _ := testServo(t, limits)
+	servo.SetAngle(45)
+	if pulse := servo.Target(); (pulse - 1000*time.Microsecond).Abs() > time.Microsecond {
+		t.Fatalf("an inverted 45 degrees targets a %v pulse", pulse)
+	}
+	if angle := servo.TargetAngle(); math.Abs(angle-45) > .01 {
+		t.Fatalf("an inverted servo targets %v degrees, want 45", angle)
+	}
+}
+
+// TestServoSteps is the hat handler stepping the servo faster than it slews,
+// each step adds to the target and not to where the servo has got to
+func TestServoSteps(t *testing.T) {
+	servo, _ := testServo(t, DefaultServoLimits)
+	for i := 0; i < 3; i++ {
+		servo.SetAngle(servo.TargetAngle() + ServoStep)
+	}
+	if angle := servo.TargetAngle(); math.Abs(angle-3*ServoStep) > .01 {
+		t.Fatalf("three steps target %v degrees, want %v", angle, 3*ServoStep)
+	}
+	if angle := servo.Angle(); angle >= 3*ServoStep {
+		t.Fatalf("the servo jumped to %v degrees", angle)
+	}
+}
+
+func TestServoSlew(t *testing.T) {
+	servo, pwm := testServo(t, DefaultServoLimits)
+	servo.SetAngle(90)
+	deadline := time.Now().Add(2 * time.Second)
+	for servo.Pulse() != servo.Target() {
+		if time.Now().After(deadline) {
+			t.Fatalf("the servo is stuck at %v", servo.Pulse())
+		}
+		time.Sleep(ServoPeriod)
+	}
+	// 2000 us per second moves the pulse by at most 40 us per period
+	step := float64(40*time.Microsecond) / float64(ServoPeriod)
+	duties := pwm.History()
+	if len(duties) < 25 {
+		t.Fatalf("the servo moved 1000 us in %d periods", len(duties)-1)
+	}
+	for i := 1; i < len(duties); i++ {
+		if delta := duties[i] - duties[i-1]; delta <= 0 || delta > step+1e-9 {
+			t.Fatalf("the duty cycle stepped from %v to %v", duties[i-1], duties[i])
+		}
+	}
+	if angle := servo.Angle(); math.Abs(angle-90) > .01 {
+		t.Fatalf("the servo stopped at %v degrees", angle)
+	}
+}
+
+func TestServoClose(t *testing.T) {
+	pwm := &fakePWM{}
+	servo := NewServo(pwm, ServoPeriod, DefaultServoLimits)
+	servo.SetAngle(90)
+	if err := servo.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !pwm.Closed {
+		t.Fatal("the pwm was not closed")
+	}
+	duties := len(pwm.History())
+	time.Sleep(3 * ServoPeriod)
+	if len(pwm.History()) != duties {
+		t.Fatal("the pulse train kept running after close")
+	}
+}
@@ -0,0 +1,27 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// ShutdownTimeout bounds how long a graceful shutdown can take
+const ShutdownTimeout = 3 * time.Second
+
+// Shutdown runs the steps in order and returns the first error, if the steps take
+// longer than timeout expired is called so that the process can exit anyway
+func Shutdown(timeout time.Duration, expired func(), steps ...func() error) error {
+	t := time.AfterFunc(timeout, expired)
+	defer t.Stop()
+	var err error
+	for i, step := range steps {
+		if e := step(); e != nil && err == nil {
+			err = fmt.Errorf("shutdown step %d: %w", i, e)
+		}
+	}
+	return err
+}
@@ -0,0 +1,112 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestShutdownHardware(t *testing.T) {
+	profile := DefaultProfile()
+	profile.Chip = ChipSim
+	profile.Ultrasonic.Enabled = true
+	h, err := OpenHardware(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gpio := h.GPIO.(*SimGPIO)
+	if err := h.L298N.SetTracks(1, -1); err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	err = Shutdown(time.Second, func() {
+		t.Error("the shutdown expired")
+	}, func() error {
+		order = append(order, "drive")
+		return h.Drive.Stop()
+	}, func() error {
+		order = append(order, "cameras")
+		// the tracks are stopped before anything else is released
+		if tracks := h.L298N.Tracks(); tracks != (Tracks{}) {
+			t.Errorf("the tracks are driving at %v", tracks)
+		}
+		return nil
+	}, func() error {
+		order = append(order, "hardware")
+		return h.Close()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(order) != 3 || order[0] != "drive" {
+		t.Fatalf("the steps ran in the order %v", order)
+	}
+	l, r := profile.Left, profile.Right
+	for _, offset := range []int{l.A, l.B, l.Enable, r.A, r.B, r.Enable, profile.Tilt.Line, profile.Pan.Line,
+		profile.Ultrasonic.Trigger, profile.Ultrasonic.Echo} {
+		if gpio.Value(offset) != 0 {
+			t.Fatalf("line %d was left high", offset)
+		}
+		if _, err := gpio.Output(offset, 0); err != nil {
+			t.Fatalf("line %d was not released: %v", offset, err)
+		}
+	}
+}
+
+func TestShutdownErrors(t *testing.T) {
+	first, second := errors.New("first"), errors.New("second")
+	ran := 0
+	err := Shutdown(time.Second, func() {
+		t.Error("the shutdown expired")
+	}, func() error {
+		ran++
+		return nil
+	}, func() error {
+		ran++
+		return first
+	}, func() error {
+		ran++
+		return second
+	})
+	// every step runs and the first error is returned
+	if ran != 3 || !errors.Is(err, first) {
+		t.Fatalf("%d steps ran and returned %v", ran, err)
+	}
+}
+
+func TestShutdownExpired(t *testing.T) {
+	expired := make(chan bool, 1)
+	err := Shutdown(20*time.Millisecond, func() {
+		expired <- true
+	}, func() error {
+		// a step that hangs
+		select {
+		case <-expired:
+			expired <- true
+		case <-time.After(time.Second):
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-expired:
+	default:
+		t.Fatal("expired was not called while a step hung")
+	}
+
+	err = Shutdown(20*time.Millisecond, func() {
+		t.Error("a quick shutdown expired")
+	}, func() error {
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(40 * time.Millisecond)
+}
@@ -0,0 +1,511 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// StereoSync is how far apart in time a left and a right frame can be captured and still be matched
+	StereoSync = 50 * time.Millisecond
+	// StereoStale is how long a depth measurement is recent
+	StereoStale = time.Second
+	// StereoPercentile is the fraction of the depths in a column that are nearer than the depth of the column,
+	// it ignores the odd bad match
+	StereoPercentile = .1
+	// StereoCoverage is the fraction of a column that must be matched for the column to have a depth
+	StereoCoverage = .02
+)
+
+// Rectifier warps a left and right image pair so that matching points are on the same row
+type Rectifier struct {
+	Width, Height int
+	// Focal is the focal length in pixels of the rectified images
+	Focal float64
+	// Baseline is the distance in meters between the cameras
+	Baseline float64
+
+	left, right *Remap
+}
+
+// NewRectifier creates a new rectifier for images of width by height, both cameras are rotated
+// so that their x axis is along the baseline and they share the left camera's viewing direction
+// as closely as possible
+func NewRectifier(calibration StereoCalibration, width, height int) (*Rectifier, error) {
+	left := calibration.Left.Scale(width, height)
+	right := calibration.Right.Scale(width, height)
+	rotation := mat3(calibration.Rotation)
+	translation := vec3(calibration.Translation)
+	// the center of the right camera in the left camera frame
+	center := rotation.transpose().mulVec(translation).scale(-1)
+	baseline := center.norm()
+	if baseline == 0 {
+		return nil, fmt.Errorf("stereo baseline is zero")
+	}
+	x := center.unit()
+	y := vec3{0, 0, 1}.cross(x)
+	if y.norm() == 0 {
+		return nil, fmt.Errorf("stereo baseline is along the viewing direction")
+	}
+	y = y.unit()
+	z := x.cross(y)
+	rectify := mat3{x[0], x[1], x[2], y[0], y[1], y[2], z[0], z[1], z[2]}.transpose()
+
+	focal := (left.Fx + left.Fy + right.Fx + right.Fy) / 4
+	cx, cy := (left.Cx+right.Cx)/2, (left.Cy+right.Cy)/2
+	ray := func(u, v int) vec3 {
+		return rectify.mulVec(vec3{(float64(u) - cx) / focal, (float64(v) - cy) / focal, 1})
+	}
+	return &Rectifier{
+		Width:    width,
+		Height:   height,
+		Focal:    focal,
+		Baseline: baseline,
+		left: NewRemap(width, height, func(u, v int) (float64, float64, bool) {
+			return left.Project(ray(u, v))
+		}),
+		right: NewRemap(width, height, func(u, v int) (float64, float64, bool) {
+			return right.Project(rotation.mulVec(ray(u, v)))
+		}),
+	}, nil
+}
+
+// Rectify warps a left and right image pair into rectified gray images
+func (r *Rectifier) Rectify(left, right image.Image) (*image.Gray, *image.Gray) {
+	return r.left.Gray(Gray(left)), r.right.Gray(Gray(right))
+}
+
+// Depth returns the depth in meters of a disparity in pixels
+func (r *Rectifier) Depth(disparity float64) float64 {
+	if disparity <= 0 {
+		return math.Inf(1)
+	}
+	return r.Focal * r.Baseline / disparity
+}
+
+// Gray returns the luminance of an image
+func Gray(img image.Image) *image.Gray {
+	b := img.Bounds()
+	switch img := img.(type) {
+	case *image.Gray:
+		return img
+	case *image.YCbCr:
+		gray := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+		for y := 0; y < b.Dy(); y++ {
+			i := img.YOffset(b.Min.X, b.Min.Y+y)
+			copy(gray.Pix[y*gray.Stride:y*gray.Stride+b.Dx()], img.Y[i:i+b.Dx()])
+		}
+		return gray
+	}
+	gray := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			gray.SetGray(x, y, color.GrayModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray))
+		}
+	}
+	return gray
+}
+
+// Remap is a table of where in a source image each pixel of a warped image comes from
+type Remap struct {
+	Width, Height int
+
+	coordinates []float32
+}
+
+// NewRemap creates a new remap of width by height, source returns the source coordinates of a pixel
+// and false if the pixel has no source
+func NewRemap(width, height int, source func(x, y int) (float64, float64, bool)) *Remap {
+	r := &Remap{
+		Width:       width,
+		Height:      height,
+		coordinates: make([]float32, 2*width*height),
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			sx, sy, ok := source(x, y)
+			if !ok {
+				sx, sy = -1, -1
+			}
+			i := 2 * (y*width + x)
+			r.coordinates[i], r.coordinates[i+1] = float32(sx), float32(sy)
+		}
+	}
+	return r
+}
+
+// sample calls set with the bilinear weights of the source pixels of each mapped pixel
+func (r *Remap) sample(w, h int, set func(i int, x0, y0, x1, y1 int, fx, fy float64)) {
+	for i := 0; i < r.Width*r.Height; i++ {
+		x, y := float64(r.coordinates[2*i]), float64(r.coordinates[2*i+1])
+		if x < 0 || y < 0 || x > float64(w-1) || y > float64(h-1) {
+			continue
+		}
+		x0, y0 := int(x), int(y)
+		x1, y1 := x0+1, y0+1
+		if x1 > w-1 {
+			x1 = w - 1
+		}
+		if y1 > h-1 {
+			y1 = h - 1
+		}
+		set(i, x0, y0, x1, y1, x-float64(x0), y-float64(y0))
+	}
+}
+
+// Gray warps a gray image with bilinear interpolation, pixels without a source are black
+func (r *Remap) Gray(src *image.Gray) *image.Gray {
+	dst := image.NewGray(image.Rect(0, 0, r.Width, r.Height))
+	b := src.Bounds()
+	r.sample(b.Dx(), b.Dy(), func(i int, x0, y0, x1, y1 int, fx, fy float64) {
+		at := func(x, y int) float64 {
+			return float64(src.Pix[y*src.Stride+x])
+		}
+		top := at(x0, y0)*(1-fx) + at(x1, y0)*fx
+		bottom := at(x0, y1)*(1-fx) + at(x1, y1)*fx
+		dst.Pix[(i/r.Width)*dst.Stride+i%r.Width] = uint8(top*(1-fy) + bottom*fy + .5)
+	})
+	return dst
+}
+
+// RGBA warps an image with bilinear interpolation, pixels without a source are black
+func (r *Remap) RGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	src, ok := img.(*image.RGBA)
+	if !ok || b.Min != (image.Point{}) {
+		src = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, r.Width, r.Height))
+	r.sample(b.Dx(), b.Dy(), func(i int, x0, y0, x1, y1 int, fx, fy float64) {
+		d := (i/r.Width)*dst.Stride + 4*(i%r.Width)
+		for c := 0; c < 4; c++ {
+			at := func(x, y int) float64 {
+				return float64(src.Pix[y*src.Stride+4*x+c])
+			}
+			top := at(x0, y0)*(1-fx) + at(x1, y0)*fx
+			bottom := at(x0, y1)*(1-fx) + at(x1, y1)*fx
+			dst.Pix[d+c] = uint8(top*(1-fy) + bottom*fy + .5)
+		}
+	})
+	return dst
+}
+
+// Disparity is a disparity map in pixels, negative disparities are unmatched
+type Disparity struct {
+	Width, Height int
+	Values        []float32
+}
+
+// At returns the disparity of a pixel
+func (d *Disparity) At(x, y int) float32 {
+	return d.Values[y*d.Width+x]
+}
+
+// Image returns the disparity map as an image, near is bright and unmatched is black
+func (d *Disparity) Image(max int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, d.Width, d.Height))
+	for i, value := range d.Values {
+		if value > 0 {
+			img.Pix[i] = uint8(math.Min(255, 1+254*float64(value)/float64(max)))
+		}
+	}
+	return img
+}
+
+// BlockMatcher finds the disparity of rectified images by matching blocks with the sum of absolute differences
+type BlockMatcher struct {
+	// MaxDisparity is the largest disparity searched in pixels
+	MaxDisparity int
+	// Window is the odd width of the square blocks
+	Window int
+	// Uniqueness is the percent by which the best match must beat the next best match that is not its neighbor
+	Uniqueness float64
+	// Texture is the least average horizontal gradient in a block for it to be matched
+	Texture float64
+}
+
+// Match returns the disparity of each pixel of the left image
+func (b BlockMatcher) Match(left, right *image.Gray) *Disparity {
+	width, height := left.Bounds().Dx(), left.Bounds().Dy()
+	disparity := &Disparity{
+		Width:  width,
+		Height: height,
+		Values: make([]float32, width*height),
+	}
+	for i := range disparity.Values {
+		disparity.Values[i] = -1
+	}
+	r, n := b.Window/2, b.MaxDisparity+1
+	if width < b.Window+n || height < b.Window {
+		return disparity
+	}
+	pixel := func(img *image.Gray, x, y int) int32 {
+		return int32(img.Pix[y*img.Stride+x])
+	}
+	// diff is the absolute difference of a left pixel and the right pixel d to its left
+	diff := func(x, y, d int) int32 {
+		if x < d {
+			return 255
+		}
+		a := pixel(left, x, y) - pixel(right, x-d, y)
+		if a < 0 {
+			return -a
+		}
+		return a
+	}
+	gradient := func(x, y int) int32 {
+		if x == 0 || x == width-1 {
+			return 0
+		}
+		g := pixel(left, x+1, y) - pixel(left, x-1, y)
+		if g < 0 {
+			return -g
+		}
+		return g
+	}
+
+	// columns holds the sums down the block rows of each column for each disparity,
+	// the last row is the texture
+	columns := make([]int32, (n+1)*width)
+	addRow := func(y int, sign int32) {
+		for d := 0; d < n; d++ {
+			row := columns[d*width : (d+1)*width]
+			for x := range row {
+				row[x] += sign * diff(x, y, d)
+			}
+		}
+		row := columns[n*width:]
+		for x := range row {
+			row[x] += sign * gradient(x, y)
+		}
+	}
+	for y := 0; y < b.Window; y++ {
+		addRow(y, 1)
+	}
+	texture := int32(b.Texture * float64(b.Window*b.Window))
+	costs := make([]int32, n)
+	for y := r; y < height-r; y++ {
+		for x := r; x < width-r; x++ {
+			sum := func(row []int32) int32 {
+				s := int32(0)
+				for _, v := range row[x-r : x+r+1] {
+					s += v
+				}
+				return s
+			}
+			if sum(columns[n*width:]) < texture {
+				continue
+			}
+			best, bestCost := -1, int32(math.MaxInt32)
+			// a block must fit in the right image
+			max := x - r
+			if max > n-1 {
+				max = n - 1
+			}
+			for d := 0; d <= max; d++ {
+				costs[d] = sum(columns[d*width : (d+1)*width])
+				if costs[d] < bestCost {
+					best, bestCost = d, costs[d]
+				}
+			}
+			if best < 0 {
+				continue
+			}
+			unique := true
+			for d := 0; d <= max; d++ {
+				if d < best-1 || d > best+1 {
+					if float64(costs[d])*100 < float64(bestCost)*(100+b.Uniqueness) {
+						unique = false
+						break
+					}
+				}
+			}
+			if !unique {
+				continue
+			}
+			value := float64(best)
+			if best > 0 && best < max {
+				// fit a parabola through the costs around the best match
+				before, after := float64(costs[best-1]), float64(costs[best+1])
+				if denominator := before - 2*float64(bestCost) + after; denominator > 0 {
+					value += (before - after) / (2 * denominator)
+				}
+			}
+			disparity.Values[y*width+x] = float32(value)
+		}
+		if y+r+1 < height {
+			addRow(y+r+1, 1)
+			addRow(y-r, -1)
+		}
+	}
+	return disparity
+}
+
+// ColumnDepths divides the rows from top to bottom, as fractions of the height, into columns and returns
+// the depth in meters of the nearest surface in each column, infinite when too little of a column is matched
+func ColumnDepths(disparity *Disparity, rectifier *Rectifier, columns int, top, bottom float64) []float64 {
+	depths := make([]float64, columns)
+	y0, y1 := int(top*float64(disparity.Height)), int(bottom*float64(disparity.Height))
+	var values []float64
+	for c := range depths {
+		x0, x1 := c*disparity.Width/columns, (c+1)*disparity.Width/columns
+		values = values[:0]
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				if d := disparity.At(x, y); d > 0 {
+					values = append(values, rectifier.Depth(float64(d)))
+				}
+			}
+		}
+		if float64(len(values)) < StereoCoverage*float64((x1-x0)*(y1-y0)) || len(values) == 0 {
+			depths[c] = math.Inf(1)
+			continue
+		}
+		sort.Float64s(values)
+		depths[c] = values[int(StereoPercentile*float64(len(values)-1))]
+	}
+	return depths
+}
+
+// StereoResult is the result of matching a stereo pair
+type StereoResult struct {
+	Left, Right *image.Gray
+	Disparity   *Disparity
+	// Depths are the depths in meters of the nearest surface in each column
+	Depths []float64
+	// Time is when the pair was captured
+	Time time.Time
+}
+
+// Stereo computes column depths from the left and right cameras
+type Stereo struct {
+	Calibration StereoCalibration
+	Matcher     BlockMatcher
+	// Columns is the number of columns of the depth
+	Columns int
+	// Top and Bottom are the rows that are searched for obstacles as fractions of the height
+	Top, Bottom float64
+
+	mutex     sync.Mutex
+	rectifier *Rectifier
+	left      Frame
+	right     Frame
+	busy      bool
+	result    *StereoResult
+	results   chan *StereoResult
+}
+
+// NewStereo creates a new stereo depth module
+func NewStereo(calibration StereoCalibration, matcher BlockMatcher, columns int, top, bottom float64) *Stereo {
+	return &Stereo{
+		Calibration: calibration,
+		Matcher:     matcher,
+		Columns:     columns,
+		Top:         top,
+		Bottom:      bottom,
+		results:     make(chan *StereoResult, 1),
+	}
+}
+
+// Compute rectifies and matches a stereo pair
+func (s *Stereo) Compute(left, right image.Image) (*StereoResult, error) {
+	b := left.Bounds()
+	if b.Dx() != right.Bounds().Dx() || b.Dy() != right.Bounds().Dy() {
+		return nil, fmt.Errorf("stereo pair sizes %v and %v differ", b, right.Bounds())
+	}
+	s.mutex.Lock()
+	rectifier := s.rectifier
+	s.mutex.Unlock()
+	if rectifier == nil || rectifier.Width != b.Dx() || rectifier.Height != b.Dy() {
+		var err error
+		rectifier, err = NewRectifier(s.Calibration, b.Dx(), b.Dy())
+		if err != nil {
+			return nil, err
+		}
+		s.mutex.Lock()
+		s.rectifier = rectifier
+		s.mutex.Unlock()
+	}
+	l, r := rectifier.Rectify(left, right)
+	disparity := s.Matcher.Match(l, r)
+	return &StereoResult{
+		Left:      l,
+		Right:     r,
+		Disparity: disparity,
+		Depths:    ColumnDepths(disparity, rectifier, s.Columns, s.Top, s.Bottom),
+	}, nil
+}
+
+// Frame adds a frame from the left or right camera, a pair captured within StereoSync
+// of each other is matched in the background unless a match is already running
+func (s *Stereo) Frame(frame Frame, left bool) {
+	s.mutex.Lock()
+	if left {
+		s.left = frame
+	} else {
+		s.right = frame
+	}
+	l, r := s.left, s.right
+	dt := l.Time.Sub(r.Time)
+	if l.Frame == nil || r.Frame == nil || dt > StereoSync || dt < -StereoSync || s.busy {
+		s.mutex.Unlock()
+		return
+	}
+	s.busy = true
+	s.left, s.right = Frame{}, Frame{}
+	s.mutex.Unlock()
+	go func() {
+		result, err := s.Compute(l.Frame, r.Frame)
+		s.mutex.Lock()
+		s.busy = false
+		if err != nil {
+			s.mutex.Unlock()
+			fmt.Println("stereo", err)
+			return
+		}
+		result.Time = l.Time
+		s.result = result
+		s.mutex.Unlock()
+		select {
+		case s.results <- result:
+		default:
+		}
+	}()
+}
+
+// Results is the stream of stereo results
+func (s *Stereo) Results() <-chan *StereoResult {
+	return s.results
+}
+
+// Depths returns the column depths of the last pair, false if there is no recent pair
+func (s *Stereo) Depths() ([]float64, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.result == nil {
+		return nil, false
+	}
+	return s.result.Depths, time.Since(s.result.Time) < StereoStale
+}
+
+// Nearest returns the depth of the nearest surface in the middle third of the columns, the path of the robot,
+// false if there is no recent pair
+func (s *Stereo) Nearest() (float64, bool) {
+	depths, ok := s.Depths()
+	nearest := math.Inf(1)
+	for _, depth := range depths[len(depths)/3 : len(depths)-len(depths)/3] {
+		nearest = math.Min(nearest, depth)
+	}
+	return nearest, ok
+}
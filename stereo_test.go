@@ -0,0 +1,205 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"image"
+	"math"
+	"testing"
+	"time"
+)
+
+// testShift is the known disparity in pixels of the shifted stereo pair
+const testShift = 8
+
+// testStereoCalibration is the synthetic stereo calibration without lens distortion,
+// so that rectification leaves the images as they are
+func testStereoCalibration(width, height int) StereoCalibration {
+	calibration := SyntheticStereoCalibration(width, height)
+	calibration.Left.Distortion = [5]float64{}
+	calibration.Right.Distortion = [5]float64{}
+	return calibration
+}
+
+// testStereoPair returns a synthetic noise frame as the left image and the same frame shifted
+// left by shift pixels as the right image, so every point is shift pixels further left in the right image
+func testStereoPair(width, height, shift int) (*image.RGBA, *image.RGBA) {
+	noise := NewSyntheticCamera("left", width, height, 0, 1, Scene{Pattern: PatternNoise, Frames: 1})
+	left := noise.Render(0)
+	right := image.NewRGBA(left.Bounds())
+	for y := 0; y < height; y++ {
+		copy(right.Pix[y*right.Stride:y*right.Stride+4*(width-shift)], left.Pix[y*left.Stride+4*shift:(y+1)*left.Stride])
+	}
+	return left, right
+}
+
+// testMatcher is a block matcher for the small test images
+var testMatcher = BlockMatcher{
+	MaxDisparity: 32,
+	Window:       7,
+	Uniqueness:   10,
+	Texture:      4,
+}
+
+func TestRectifier(t *testing.T) {
+	rectifier, err := NewRectifier(testStereoCalibration(64, 48), 64, 48)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(rectifier.Focal-.8*64) > 1e-9 || math.Abs(rectifier.Baseline-SyntheticBaseline) > 1e-9 {
+		t.Fatalf("the focal length is %v and the baseline is %v", rectifier.Focal, rectifier.Baseline)
+	}
+	if depth := rectifier.Depth(testShift); math.Abs(depth-.8*64*SyntheticBaseline/testShift) > 1e-9 {
+		t.Fatalf("a disparity of %d is %v m deep", testShift, depth)
+	}
+	if depth := rectifier.Depth(0); !math.IsInf(depth, 1) {
+		t.Fatalf("no disparity is %v m deep", depth)
+	}
+	// cameras that are already rectified are left as they are
+	left, right := testStereoPair(64, 48, testShift)
+	l, r := rectifier.Rectify(left, right)
+	for _, pair := range []struct {
+		rectified, original *image.Gray
+	}{{l, Gray(left)}, {r, Gray(right)}} {
+		for y := 1; y < 47; y++ {
+			for x := 1; x < 63; x++ {
+				a, b := int(pair.rectified.GrayAt(x, y).Y), int(pair.original.GrayAt(x, y).Y)
+				if a-b > 1 || b-a > 1 {
+					t.Fatalf("pixel %d,%d was rectified from %d to %d", x, y, b, a)
+				}
+			}
+		}
+	}
+
+	calibration := testStereoCalibration(64, 48)
+	calibration.Translation = [3]float64{}
+	if _, err := NewRectifier(calibration, 64, 48); err == nil {
+		t.Fatal("a zero baseline was accepted")
+	}
+	calibration.Translation = [3]float64{0, 0, -SyntheticBaseline}
+	if _, err := NewRectifier(calibration, 64, 48); err == nil {
+		t.Fatal("a baseline along the viewing direction was accepted")
+	}
+}
+
+func TestBlockMatcher(t *testing.T) {
+	left, right := testStereoPair(96, 48, testShift)
+	disparity := testMatcher.Match(Gray(left), Gray(right))
+	r := testMatcher.Window / 2
+	matched, total := 0, 0
+	// blocks further left than the shift have no match in the right image
+	for y := r; y < 48-r; y++ {
+		for x := testShift + r; x < 96-r; x++ {
+			total++
+			d := disparity.At(x, y)
+			if d < 0 {
+				continue
+			}
+			matched++
+			if math.Abs(float64(d)-testShift) > .5 {
+				t.Fatalf("pixel %d,%d has a disparity of %v, want %d", x, y, d, testShift)
+			}
+		}
+	}
+	if matched < total*9/10 {
+		t.Fatalf("%d of %d pixels are matched", matched, total)
+	}
+
+	// a flat image has no texture to match
+	flat := image.NewGray(image.Rect(0, 0, 96, 48))
+	for i := range flat.Pix {
+		flat.Pix[i] = 128
+	}
+	for i, d := range testMatcher.Match(flat, flat).Values {
+		if d >= 0 {
+			t.Fatalf("pixel %d of a flat image has a disparity of %v", i, d)
+		}
+	}
+}
+
+func TestColumnDepths(t *testing.T) {
+	rectifier, err := NewRectifier(testStereoCalibration(40, 10), 40, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	disparity := &Disparity{
+		Width:  40,
+		Height: 10,
+		Values: make([]float32, 40*10),
+	}
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 40; x++ {
+			var d float32
+			switch {
+			case x < 10:
+				// a wall
+				d = 4
+			case x < 20:
+				// a wall with a nearer post in a fifth of it
+				d = 4
+				if x < 12 {
+					d = 16
+				}
+			case x < 30:
+				// a wall with one bad match
+				d = 4
+				if x == 25 && y == 5 {
+					d = 32
+				}
+			default:
+				d = -1
+			}
+			disparity.Values[y*40+x] = d
+		}
+	}
+	depth := func(d float64) float64 {
+		return rectifier.Focal * rectifier.Baseline / d
+	}
+	want := []float64{depth(4), depth(16), depth(4), math.Inf(1)}
+	depths := ColumnDepths(disparity, rectifier, 4, 0, 1)
+	for i := range want {
+		if depths[i] != want[i] {
+			t.Fatalf("column %d is %v m deep, want %v m", i, depths[i], want[i])
+		}
+	}
+	// a band without rows has no depth
+	if depths := ColumnDepths(disparity, rectifier, 4, .5, .5); !math.IsInf(depths[1], 1) {
+		t.Fatalf("a column without rows is %v m deep", depths[1])
+	}
+}
+
+func TestStereoNearest(t *testing.T) {
+	stereo := NewStereo(testStereoCalibration(96, 48), testMatcher, 3, 0, 1)
+	if _, ok := stereo.Nearest(); ok {
+		t.Fatal("ok before the first pair")
+	}
+	left, right := testStereoPair(96, 48, testShift)
+	now := time.Now()
+	stereo.Frame(Frame{Frame: left, Time: now}, true)
+	// a right frame that is too late is not paired
+	stereo.Frame(Frame{Frame: right, Time: now.Add(2 * StereoSync)}, false)
+	select {
+	case <-stereo.Results():
+		t.Fatal("frames too far apart were paired")
+	case <-time.After(10 * time.Millisecond):
+	}
+	stereo.Frame(Frame{Frame: right, Time: now.Add(StereoSync / 2)}, false)
+	select {
+	case <-stereo.Results():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the pair was not matched")
+	}
+	nearest, ok := stereo.Nearest()
+	if !ok {
+		t.Fatal("not ok after a pair")
+	}
+	rectifier, err := NewRectifier(stereo.Calibration, 96, 48)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := rectifier.Depth(testShift); math.Abs(nearest-want)/want > .05 {
+		t.Fatalf("the nearest surface is %v m away, want %v m", nearest, want)
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrCameraStalled is the failure of a camera that stopped sending frames without an error
+var ErrCameraStalled = errors.New("camera stopped sending frames")
+
+const (
+	// SupervisorBackoff is the wait before the first restart of a failed camera
+	SupervisorBackoff = 500 * time.Millisecond
+	// SupervisorMaxBackoff is the longest wait between restarts, a camera that streams this long is healthy again
+	SupervisorMaxBackoff = 30 * time.Second
+	// SupervisorStale is how long a camera can go without a frame before its inputs are stale and it is restarted
+	SupervisorStale = 2 * time.Second
+	// SupervisorStartup is how long a camera that just started has to send its first frame
+	SupervisorStartup = 10 * time.Second
+	// SupervisorReport is the period at which the health of the cameras is reported
+	SupervisorReport = 5 * time.Second
+)
+
+// CameraState is the state of a supervised camera
+type CameraState int
+
+const (
+	// CameraStarting is a camera that is being opened
+	CameraStarting CameraState = iota
+	// CameraRunning is a camera that is streaming
+	CameraRunning
+	// CameraRestarting is a camera that failed and is waiting to be restarted
+	CameraRestarting
+	// CameraStopped is a camera that is no longer supervised
+	CameraStopped
+)
+
+// String returns the name of the camera state
+func (c CameraState) String() string {
+	switch c {
+	case CameraStarting:
+		return "starting"
+	case CameraRunning:
+		return "running"
+	case CameraRestarting:
+		return "restarting"
+	case CameraStopped:
+		return "stopped"
+	}
+	return fmt.Sprintf("CameraState(%d)", int(c))
+}
+
+// CameraHealth is the health of a supervised camera
+type CameraHealth struct {
+	Name  string
+	State CameraState
+	// Frames is the number of frames received
+	Frames uint64
+	// Restarts is the number of times the camera failed and was restarted
+	Restarts int
+	// LastFrame is when the last frame was received
+	LastFrame time.Time
+	// Age is how long after its capture the last frame was received
+	Age time.Duration
+	// Err is the error of the last failure
+	Err error
+	// Retry is when a restarting camera is started again
+	Retry time.Time
+}
+
+// String returns a description of the health of the camera
+func (c CameraHealth) String() string {
+	s := fmt.Sprintf("%s %s frames %d restarts %d", c.Name, c.State, c.Frames, c.Restarts)
+	if !c.LastFrame.IsZero() {
+		s += fmt.Sprintf(" last frame %v ago age %v", time.Since(c.LastFrame).Round(time.Millisecond),
+			c.Age.Round(time.Millisecond))
+	}
+	if c.Err != nil {
+		s += fmt.Sprintf(" error %v", c.Err)
+	}
+	if c.State == CameraRestarting {
+		s += fmt.Sprintf(" retry in %v", time.Until(c.Retry).Round(time.Millisecond))
+	}
+	return s
+}
+
+// Supervisor is a camera that restarts the camera it supervises with backoff when it fails,
+// a camera that ends without an error such as a replay that finished is not restarted
+type Supervisor struct {
+	cameraStream
+	Camera Camera
+	// Backoff is the wait before the first restart, it doubles after each failure up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Timeout is how long the camera can go without a frame before it is stale and restarted,
+	// it must be longer than the interval between frames at the rate of the camera
+	Timeout time.Duration
+	// Startup is how long the camera has to send its first frame after it starts
+	Startup time.Duration
+
+	health CameraHealth
+}
+
+// NewSupervisor creates a new supervisor for a camera
+func NewSupervisor(camera Camera) *Supervisor {
+	return &Supervisor{
+		cameraStream: newCameraStream(camera.Info(), 0),
+		Camera:       camera,
+		Backoff:      SupervisorBackoff,
+		MaxBackoff:   SupervisorMaxBackoff,
+		Timeout:      SupervisorStale,
+		Startup:      SupervisorStartup,
+		health: CameraHealth{
+			Name:  camera.Info().Name,
+			State: CameraStopped,
+		},
+	}
+}
+
+// Start starts the camera and keeps restarting it until ctx is canceled or Stop is called
+func (s *Supervisor) Start(ctx context.Context) error {
+	ctx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	go func() {
+		s.end(s.supervise(ctx))
+	}()
+	return nil
+}
+
+// supervise runs the camera until ctx is canceled or the camera ends without an error,
+// a camera that stalls is stopped and restarted like one that failed
+func (s *Supervisor) supervise(ctx context.Context) error {
+	defer s.setState(CameraStopped)
+	backoff := s.Backoff
+	for {
+		s.setState(CameraStarting)
+		started := time.Now()
+		err := s.Camera.Start(ctx)
+		if err == nil {
+			s.setState(CameraRunning)
+			fmt.Println("camera", s.Camera.Info())
+			stalled := s.forward(s.Camera.Frames())
+			err = s.Camera.Stop()
+			if stalled && err == nil {
+				err = ErrCameraStalled
+			}
+		}
+		if ctx.Err() != nil || err == nil {
+			return err
+		}
+		if time.Since(started) >= s.MaxBackoff {
+			backoff = s.Backoff
+		}
+		retry := time.Now().Add(backoff)
+		s.mutex.Lock()
+		s.health.State, s.health.Err, s.health.Retry = CameraRestarting, err, retry
+		s.health.Restarts++
+		s.mutex.Unlock()
+		fmt.Printf("camera %s failed: %v, restarting in %v\n", s.Camera.Info().Name, err, backoff)
+		t := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil
+		case <-t.C:
+		}
+		backoff *= 2
+		if backoff > s.MaxBackoff {
+			backoff = s.MaxBackoff
+		}
+	}
+}
+
+// forward counts the frames of the camera and sends them on until the camera ends or stalls,
+// a frame is dropped if the last one is unread, it returns true if the camera stalled
+func (s *Supervisor) forward(frames <-chan Frame) bool {
+	stall := time.NewTimer(s.Startup)
+	defer stall.Stop()
+	for {
+		select {
+		case frame, ok := <-frames:
+			if !ok {
+				return false
+			}
+			s.mutex.Lock()
+			s.health.Frames++
+			s.health.LastFrame = time.Now()
+			if !frame.Time.IsZero() {
+				s.health.Age = s.health.LastFrame.Sub(frame.Time)
+			}
+			s.mutex.Unlock()
+			select {
+			case s.frames <- frame:
+			default:
+			}
+			if !stall.Stop() {
+				<-stall.C
+			}
+			stall.Reset(s.Timeout)
+		case <-stall.C:
+			return true
+		}
+	}
+}
+
+// setState sets the state of the camera
+func (s *Supervisor) setState(state CameraState) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.health.State = state
+}
+
+// Info describes the supervised camera
+func (s *Supervisor) Info() CameraInfo {
+	return s.Camera.Info()
+}
+
+// Health returns the health of the camera
+func (s *Supervisor) Health() CameraHealth {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.health
+}
+
+// Stale returns true when the camera is not streaming or has not sent a frame recently
+func (s *Supervisor) Stale() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.health.State != CameraRunning || time.Since(s.health.LastFrame) > s.Timeout
+}
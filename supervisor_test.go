@@ -0,0 +1,196 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// errUnplugged is the error of a test camera that fails
+var errUnplugged = errors.New("unplugged")
+
+// testCamera is a camera that sends a number of frames and then fails, stalls or ends
+type testCamera struct {
+	cameraStream
+	// Count is the number of frames sent each time the camera starts
+	Count int
+	// Fail is the error that ends streaming, context.Canceled ends without an error like a replay that finished
+	// and nil stalls until the camera is stopped
+	Fail error
+	// Open is the error of Start
+	Open error
+
+	mutex  sync.Mutex
+	starts int
+}
+
+// newTestCamera creates a new test camera
+func newTestCamera(count int, fail, open error) *testCamera {
+	return &testCamera{
+		cameraStream: newCameraStream(CameraInfo{Name: "center", Device: "test"}, 0),
+		Count:        count,
+		Fail:         fail,
+		Open:         open,
+	}
+}
+
+// Start sends the frames and then fails or stalls
+func (c *testCamera) Start(ctx context.Context) error {
+	ctx, err := c.begin(ctx)
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	c.starts++
+	c.mutex.Unlock()
+	if c.Open != nil {
+		c.end(c.Open)
+		return c.Open
+	}
+	go func() {
+		for i := 0; i < c.Count; i++ {
+			c.capture(time.Now())
+			select {
+			case c.frames <- c.stamp(Frame{}):
+			case <-ctx.Done():
+				c.end(nil)
+				return
+			}
+		}
+		if c.Fail != nil {
+			c.end(c.Fail)
+			return
+		}
+		<-ctx.Done()
+		c.end(nil)
+	}()
+	return nil
+}
+
+// Starts returns the number of times the camera started
+func (c *testCamera) Starts() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.starts
+}
+
+// supervise starts a supervisor with short timings that first waits backoff to restart the camera
+func supervise(t *testing.T, camera Camera, backoff time.Duration) *Supervisor {
+	t.Helper()
+	s := NewSupervisor(camera)
+	s.Backoff, s.MaxBackoff = backoff, 4*backoff
+	s.Timeout, s.Startup = 50*time.Millisecond, 50*time.Millisecond
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// drain reads the frames of a supervisor until it stops
+func drain(s *Supervisor) {
+	go func() {
+		for range s.Frames() {
+		}
+	}()
+}
+
+func TestSupervisorRestartsFailures(t *testing.T) {
+	tests := []struct {
+		name   string
+		camera *testCamera
+		err    error
+	}{
+		{"failing", newTestCamera(3, errUnplugged, nil), errUnplugged},
+		{"not opening", newTestCamera(0, nil, errUnplugged), errUnplugged},
+		{"stalling", newTestCamera(3, nil, nil), ErrCameraStalled},
+	}
+	for _, test := range tests {
+		s := supervise(t, test.camera, 10*time.Millisecond)
+		drain(s)
+		time.Sleep(400 * time.Millisecond)
+		health := s.Health()
+		t.Logf("%s: %v", test.name, health)
+		if health.Restarts < 3 || test.camera.Starts() < 3 || !errors.Is(health.Err, test.err) {
+			t.Fatalf("%s: %v", test.name, health)
+		}
+		if err := s.Stop(); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if s.Health().State != CameraStopped {
+			t.Fatalf("%s: %v", test.name, s.Health())
+		}
+	}
+}
+
+func TestSupervisorBackoff(t *testing.T) {
+	camera := newTestCamera(0, nil, errUnplugged)
+	s := supervise(t, camera, 10*time.Millisecond)
+	drain(s)
+	// the waits are 10, 20, 40, 40 and 40 ms
+	time.Sleep(175 * time.Millisecond)
+	if starts := camera.Starts(); starts < 5 || starts > 7 {
+		t.Fatalf("the camera started %d times in 175 ms", starts)
+	}
+	s.Stop()
+}
+
+func TestSupervisorStale(t *testing.T) {
+	camera := newTestCamera(5, nil, nil)
+	s := supervise(t, camera, time.Second)
+	for i := 0; i < 5; i++ {
+		<-s.Frames()
+	}
+	if s.Stale() {
+		t.Fatalf("a streaming camera is stale: %v", s.Health())
+	}
+	if age := s.Health().Age; age < 0 || age > s.Timeout {
+		t.Fatalf("the last frame arrived %v after it was captured", age)
+	}
+	// the camera stalls, it is stale and then waits to be restarted
+	time.Sleep(2 * s.Timeout)
+	if !s.Stale() {
+		t.Fatalf("a stalled camera is not stale: %v", s.Health())
+	}
+	s.Stop()
+	if !s.Stale() {
+		t.Fatal("a stopped camera is not stale")
+	}
+}
+
+func TestSupervisorEnds(t *testing.T) {
+	// a replay that finishes is not restarted
+	camera := newTestCamera(0, context.Canceled, nil)
+	s := supervise(t, camera, 10*time.Millisecond)
+	for range s.Frames() {
+	}
+	if camera.Starts() != 1 || s.Health().Restarts != 0 {
+		t.Fatalf("a camera that ended was restarted: %v", s.Health())
+	}
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCameraRestart(t *testing.T) {
+	camera := NewSyntheticCamera("center", 64, 48, 0, 1)
+	for i := 0; i < 2; i++ {
+		if err := camera.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if err := camera.Start(context.Background()); err != ErrCameraStarted {
+			t.Fatalf("a started camera started again: %v", err)
+		}
+		if frame, ok := <-camera.Frames(); !ok || frame.Frame == nil {
+			t.Fatalf("start %d sent no frame", i)
+		}
+		if err := camera.Stop(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
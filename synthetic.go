@@ -0,0 +1,401 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// SyntheticWidth is the default width of synthetic frames
+	SyntheticWidth = 320
+	// SyntheticHeight is the default height of synthetic frames
+	SyntheticHeight = 240
+	// SyntheticRate is the default frame rate of a synthetic camera
+	SyntheticRate = 15
+	// SyntheticShapes is the number of moving shapes
+	SyntheticShapes = 4
+	// SyntheticFlash is the number of frames between brightness changes
+	SyntheticFlash = 15
+	// SyntheticBaseline is the distance in meters between the synthetic left and right cameras
+	SyntheticBaseline = .06
+	// SyntheticColumns and SyntheticRows are the inner corners of the synthetic checkerboard
+	SyntheticColumns, SyntheticRows = 9, 6
+	// SyntheticSquare is the size in meters of a square of the synthetic checkerboard
+	SyntheticSquare = .025
+	// SyntheticPoses is the number of frames the synthetic checkerboard holds a pose
+	SyntheticPoses = 5
+)
+
+// Pattern is a procedurally generated test pattern
+type Pattern int
+
+const (
+	// PatternBars is vertical color bars
+	PatternBars Pattern = iota
+	// PatternShapes is rectangles moving across a gray background
+	PatternShapes
+	// PatternNoise is uniform random noise
+	PatternNoise
+	// PatternFlash is color bars whose brightness changes suddenly
+	PatternFlash
+	// PatternCheckerboard is a checkerboard in a new pose every SyntheticPoses frames seen through a lens,
+	// the left and right cameras see it from SyntheticBaseline apart
+	PatternCheckerboard
+)
+
+// patterns are the names of the patterns
+var patterns = [...]string{"bars", "shapes", "noise", "flash", "checkerboard"}
+
+// String returns the name of the pattern
+func (p Pattern) String() string {
+	if p >= 0 && int(p) < len(patterns) {
+		return patterns[p]
+	}
+	return fmt.Sprintf("Pattern(%d)", int(p))
+}
+
+// Scene shows a pattern for a number of frames
+type Scene struct {
+	Pattern Pattern
+	Frames  int
+}
+
+// ParseScript parses a script such as "bars:30,shapes:100,flash:50" into scenes
+func ParseScript(script string) ([]Scene, error) {
+	var scenes []Scene
+	for _, part := range strings.Split(script, ",") {
+		name, frames, found := strings.Cut(strings.TrimSpace(part), ":")
+		scene := Scene{
+			Pattern: -1,
+			Frames:  SyntheticRate,
+		}
+		for i, pattern := range patterns {
+			if pattern == name {
+				scene.Pattern = Pattern(i)
+			}
+		}
+		if scene.Pattern < 0 {
+			return nil, fmt.Errorf("pattern %q must be one of %s", name, strings.Join(patterns[:], ", "))
+		}
+		if found {
+			n, err := strconv.Atoi(frames)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("pattern %s frames %q must be a positive number", name, frames)
+			}
+			scene.Frames = n
+		}
+		scenes = append(scenes, scene)
+	}
+	return scenes, nil
+}
+
+// SyntheticCamera is a camera that generates test patterns, the frames depend only on the seed,
+// the script and the frame number so that runs are reproducible
+type SyntheticCamera struct {
+	cameraStream
+	Width, Height int
+	// Rate is the frame rate, zero sends frames as fast as they are read
+	Rate float64
+	Seed int64
+	// Script is the scenes shown in order, it repeats at the end
+	Script []Scene
+
+	shapes []syntheticShape
+	rays   []vec3
+}
+
+// syntheticShape is a moving rectangle
+type syntheticShape struct {
+	size  image.Point
+	start image.Point
+	speed image.Point
+	color color.RGBA
+}
+
+// NewSyntheticCamera creates a new synthetic camera, an empty script shows color bars
+func NewSyntheticCamera(name string, width, height int, rate float64, seed int64, script ...Scene) *SyntheticCamera {
+	if len(script) == 0 {
+		script = []Scene{{Pattern: PatternBars, Frames: 1}}
+	}
+	rng := rand.New(rand.NewSource(seed))
+	shapes := make([]syntheticShape, SyntheticShapes)
+	for i := range shapes {
+		shapes[i] = syntheticShape{
+			size:  image.Pt(width/8+rng.Intn(width/4+1), height/8+rng.Intn(height/4+1)),
+			start: image.Pt(rng.Intn(width+1), rng.Intn(height+1)),
+			speed: image.Pt(rng.Intn(9)-4, rng.Intn(9)-4),
+			color: color.RGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 255},
+		}
+	}
+	return &SyntheticCamera{
+		cameraStream: newCameraStream(CameraInfo{
+			Name:   name,
+			Device: "synthetic",
+			Format: "rgba",
+			Width:  width,
+			Height: height,
+		}, 0),
+		Width:  width,
+		Height: height,
+		Rate:   rate,
+		Seed:   seed,
+		Script: script,
+		shapes: shapes,
+	}
+}
+
+// NewSyntheticModeCamera creates a synthetic camera of the size and processing rate of a camera mode,
+// a mode without a size gets the default size
+func NewSyntheticModeCamera(name string, mode CameraMode, seed int64, script ...Scene) *SyntheticCamera {
+	width, height := mode.Width, mode.Height
+	if width == 0 || height == 0 {
+		width, height = SyntheticWidth, SyntheticHeight
+	}
+	camera := NewSyntheticCamera(name, width, height, SyntheticRate, seed, script...)
+	camera.SetRate(mode.Rate)
+	return camera
+}
+
+// Start generates frames until ctx is canceled or Stop is called
+func (sc *SyntheticCamera) Start(ctx context.Context) error {
+	ctx, err := sc.begin(ctx)
+	if err != nil {
+		return err
+	}
+	if sc.Width <= 0 || sc.Height <= 0 {
+		err := fmt.Errorf("synthetic size %dx%d must be positive", sc.Width, sc.Height)
+		sc.end(err)
+		return err
+	}
+	go func() {
+		sc.generate(ctx)
+		sc.end(nil)
+	}()
+	return nil
+}
+
+// generate sends frames at the rate until ctx is canceled
+func (sc *SyntheticCamera) generate(ctx context.Context) {
+	var tick <-chan time.Time
+	if sc.Rate > 0 {
+		t := time.NewTicker(time.Duration(float64(time.Second) / sc.Rate))
+		defer t.Stop()
+		tick = t.C
+	}
+	for n := 0; ; n++ {
+		frame := Frame{
+			Frame: sc.Render(n),
+		}
+		if tick == nil {
+			// every frame is sent as fast as it is read, so the rate does not apply
+			sc.capture(time.Now())
+			select {
+			case sc.frames <- sc.stamp(frame):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		select {
+		case at := <-tick:
+			if sc.capture(at) {
+				sc.send(frame)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Scene returns the scene of frame n and the frame number within the scene
+func (sc *SyntheticCamera) Scene(n int) (Scene, int) {
+	total := 0
+	for _, scene := range sc.Script {
+		total += scene.Frames
+	}
+	n %= total
+	for _, scene := range sc.Script {
+		if n < scene.Frames {
+			return scene, n
+		}
+		n -= scene.Frames
+	}
+	return sc.Script[0], 0
+}
+
+// Render renders frame n
+func (sc *SyntheticCamera) Render(n int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, sc.Width, sc.Height))
+	scene, _ := sc.Scene(n)
+	switch scene.Pattern {
+	case PatternBars:
+		sc.bars(img, 1)
+	case PatternShapes:
+		sc.shapesAt(img, n)
+	case PatternNoise:
+		rng := rand.New(rand.NewSource(sc.Seed + int64(n)))
+		rng.Read(img.Pix)
+		for i := 3; i < len(img.Pix); i += 4 {
+			img.Pix[i] = 255
+		}
+	case PatternFlash:
+		// a seeded brightness holds for SyntheticFlash frames and then jumps
+		rng := rand.New(rand.NewSource(sc.Seed + int64(n/SyntheticFlash)))
+		sc.bars(img, .1+.9*rng.Float64())
+	case PatternCheckerboard:
+		sc.checkerboard(img, n)
+	}
+	return img
+}
+
+// SyntheticCalibration returns the calibration of the lens of a synthetic camera
+func SyntheticCalibration(width, height int) CameraCalibration {
+	return CameraCalibration{
+		Width:      width,
+		Height:     height,
+		Fx:         .8 * float64(width),
+		Fy:         .8 * float64(width),
+		Cx:         float64(width) / 2,
+		Cy:         float64(height) / 2,
+		Distortion: [5]float64{-.1, .02},
+	}
+}
+
+// SyntheticStereoCalibration returns the calibration of the synthetic left and right cameras
+func SyntheticStereoCalibration(width, height int) StereoCalibration {
+	return StereoCalibration{
+		Left:        SyntheticCalibration(width, height),
+		Right:       SyntheticCalibration(width, height),
+		Rotation:    identity3,
+		Translation: [3]float64{-SyntheticBaseline, 0, 0},
+	}
+}
+
+// checkerboardPose returns the rotation and the translation of the center of the synthetic checkerboard in frame n,
+// the pose depends only on the frame so that every camera sees the same board
+func checkerboardPose(n int) (mat3, vec3) {
+	rng := rand.New(rand.NewSource(int64(n / SyntheticPoses)))
+	angle := func(degrees float64) float64 {
+		return (2*rng.Float64() - 1) * degrees * math.Pi / 180
+	}
+	rotation := rodrigues(vec3{angle(30), angle(30), angle(10)})
+	translation := vec3{(2*rng.Float64() - 1) * .04, (2*rng.Float64() - 1) * .03, .4 + .25*rng.Float64()}
+	return rotation, translation
+}
+
+// checkerboard draws the synthetic checkerboard in its pose in frame n
+func (sc *SyntheticCamera) checkerboard(img *image.RGBA, n int) {
+	rotation, translation := checkerboardPose(n)
+	center := vec3{(SyntheticColumns - 1) * SyntheticSquare / 2, (SyntheticRows - 1) * SyntheticSquare / 2, 0}
+	normal := rotation.mulVec(vec3{0, 0, 1})
+
+	var eye vec3
+	switch sc.Info().Name {
+	case "left":
+		eye[0] = -SyntheticBaseline / 2
+	case "right":
+		eye[0] = SyntheticBaseline / 2
+	}
+	// four rays per pixel through the lens smooth the edges
+	offsets := [4][2]float64{{-.25, -.25}, {.25, -.25}, {-.25, .25}, {.25, .25}}
+	if sc.rays == nil {
+		lens := SyntheticCalibration(sc.Width, sc.Height)
+		sc.rays = make([]vec3, 0, len(offsets)*sc.Width*sc.Height)
+		for y := 0; y < sc.Height; y++ {
+			for x := 0; x < sc.Width; x++ {
+				for _, d := range offsets {
+					u, v := lens.Undistort((float64(x)+d[0]-lens.Cx)/lens.Fx, (float64(y)+d[1]-lens.Cy)/lens.Fy)
+					sc.rays = append(sc.rays, vec3{u, v, 1})
+				}
+			}
+		}
+	}
+	shade := func(ray vec3) float64 {
+		t := normal.dot(translation.sub(eye)) / normal.dot(ray)
+		if t <= 0 {
+			return 90
+		}
+		// where the ray meets the board in board coordinates
+		p := rotation.transpose().mulVec(eye.add(ray.scale(t)).sub(translation)).add(center)
+		i, j := math.Floor(p[0]/SyntheticSquare)+1, math.Floor(p[1]/SyntheticSquare)+1
+		if i < -1 || j < -1 || i > SyntheticColumns+1 || j > SyntheticRows+1 {
+			return 90
+		}
+		if i < 0 || j < 0 || i > SyntheticColumns || j > SyntheticRows || int(i+j)%2 == 1 {
+			return 230
+		}
+		return 25
+	}
+	for y := 0; y < sc.Height; y++ {
+		for x := 0; x < sc.Width; x++ {
+			sum := 0.0
+			for _, ray := range sc.rays[len(offsets)*(y*sc.Width+x) : len(offsets)*(y*sc.Width+x+1)] {
+				sum += shade(ray)
+			}
+			gray := uint8(sum / float64(len(offsets)))
+			img.SetRGBA(x, y, color.RGBA{gray, gray, gray, 255})
+		}
+	}
+}
+
+// bars draws the eight standard color bars scaled by brightness
+func (sc *SyntheticCamera) bars(img *image.RGBA, brightness float64) {
+	bars := [...]color.RGBA{
+		{255, 255, 255, 255}, {255, 255, 0, 255}, {0, 255, 255, 255}, {0, 255, 0, 255},
+		{255, 0, 255, 255}, {255, 0, 0, 255}, {0, 0, 255, 255}, {0, 0, 0, 255},
+	}
+	for x := 0; x < sc.Width; x++ {
+		bar := bars[x*len(bars)/sc.Width]
+		c := color.RGBA{
+			R: uint8(float64(bar.R) * brightness),
+			G: uint8(float64(bar.G) * brightness),
+			B: uint8(float64(bar.B) * brightness),
+			A: 255,
+		}
+		for y := 0; y < sc.Height; y++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+}
+
+// shapesAt draws the shapes where they are in frame n, they bounce off the edges
+func (sc *SyntheticCamera) shapesAt(img *image.RGBA, n int) {
+	gray := color.RGBA{128, 128, 128, 255}
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = gray.R, gray.G, gray.B, gray.A
+	}
+	bounce := func(start, speed, limit int) int {
+		if limit <= 0 {
+			return 0
+		}
+		p := (start + speed*n) % (2 * limit)
+		if p < 0 {
+			p += 2 * limit
+		}
+		if p > limit {
+			p = 2*limit - p
+		}
+		return p
+	}
+	for _, shape := range sc.shapes {
+		x := bounce(shape.start.X, shape.speed.X, sc.Width-shape.size.X)
+		y := bounce(shape.start.Y, shape.speed.Y, sc.Height-shape.size.Y)
+		r := image.Rect(x, y, x+shape.size.X, y+shape.size.Y).Intersect(img.Bounds())
+		for yy := r.Min.Y; yy < r.Max.Y; yy++ {
+			for xx := r.Min.X; xx < r.Max.X; xx++ {
+				img.SetRGBA(xx, yy, shape.color)
+			}
+		}
+	}
+}
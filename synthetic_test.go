@@ -0,0 +1,162 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// testScript shows every pattern
+const testScript = "bars:2,shapes:3,noise:2,flash:40,checkerboard:5"
+
+func TestParseScript(t *testing.T) {
+	scenes, err := ParseScript(testScript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Scene{{PatternBars, 2}, {PatternShapes, 3}, {PatternNoise, 2}, {PatternFlash, 40}, {PatternCheckerboard, 5}}
+	if len(scenes) != len(want) {
+		t.Fatal(scenes)
+	}
+	for i := range want {
+		if scenes[i] != want[i] {
+			t.Fatalf("scene %d is %+v, want %+v", i, scenes[i], want[i])
+		}
+	}
+	if scenes, err := ParseScript("shapes"); err != nil || scenes[0].Frames != SyntheticRate {
+		t.Fatal(scenes, err)
+	}
+	for _, script := range []string{"bogus", "bars:0", "bars:x", "bars:2,"} {
+		if _, err := ParseScript(script); err == nil {
+			t.Fatalf("script %q was accepted", script)
+		}
+	}
+}
+
+func TestSyntheticRender(t *testing.T) {
+	script, err := ParseScript(testScript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := NewSyntheticCamera("center", 64, 48, 0, 7, script...)
+	b := NewSyntheticCamera("center", 64, 48, 0, 7, script...)
+	other := NewSyntheticCamera("center", 64, 48, 0, 8, script...)
+	differs := false
+	for n := 0; n < 60; n++ {
+		frame := a.Render(n)
+		if !bytes.Equal(frame.Pix, b.Render(n).Pix) {
+			t.Fatalf("frame %d differs with the same seed", n)
+		}
+		if !bytes.Equal(frame.Pix, a.Render(n).Pix) {
+			t.Fatalf("frame %d differs when rendered again", n)
+		}
+		if !bytes.Equal(frame.Pix, other.Render(n).Pix) {
+			differs = true
+		}
+	}
+	if !differs {
+		t.Fatal("the seed does not change the frames")
+	}
+	if bytes.Equal(a.Render(2).Pix, a.Render(3).Pix) {
+		t.Fatal("the shapes do not move")
+	}
+}
+
+func TestConvert(t *testing.T) {
+	camera := NewSyntheticCamera("center", 64, 48, 0, 1)
+	frame := camera.Render(0)
+	input := Convert(1, frame)
+	if input.Source != 1 || input.Width != 64 || input.Height != 48 || len(input.YCbCr) != 3*64*48 {
+		t.Fatalf("input is %d %dx%d with %d values", input.Source, input.Width, input.Height, len(input.YCbCr))
+	}
+	pixel := frame.RGBAAt(0, 0)
+	y, cb, cr := color.RGBToYCbCr(pixel.R, pixel.G, pixel.B)
+	if input.YCbCr[0] != uint32(y) || input.YCbCr[1] != uint32(cb) || input.YCbCr[2] != uint32(cr) {
+		t.Fatalf("the first pixel is %v, want %d %d %d", input.YCbCr[:3], y, cb, cr)
+	}
+	again := Convert(1, camera.Render(0))
+	for i := range input.YCbCr {
+		if input.YCbCr[i] != again.YCbCr[i] {
+			t.Fatalf("value %d differs when converted again", i)
+		}
+	}
+}
+
+func TestProcessSynthetic(t *testing.T) {
+	script, err := ParseScript("shapes:10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	camera := NewSyntheticCamera("center", SyntheticWidth, SyntheticHeight, 0, 3, script...)
+	var outputs [2][]Frame
+	for i := range outputs {
+		processor, output := NewFrameProcessor(1), make(chan Frame, 1)
+		go processor.Process(output)
+		for n := 0; n < 3; n++ {
+			processor.Input <- Convert(1, camera.Render(n))
+			outputs[i] = append(outputs[i], <-output)
+		}
+		close(processor.Input)
+	}
+	for n := range outputs[0] {
+		a, b := outputs[0][n], outputs[1][n]
+		if len(a.Query.Data) == 0 {
+			t.Fatalf("frame %d has no query", n)
+		}
+		for _, m := range [][2][]float32{{a.Query.Data, b.Query.Data}, {a.Key.Data, b.Key.Data}, {a.Value.Data, b.Value.Data}} {
+			if len(m[0]) != len(m[1]) {
+				t.Fatalf("frame %d outputs differ in size", n)
+			}
+			for i := range m[0] {
+				if m[0][i] != m[1][i] {
+					t.Fatalf("frame %d output %d differs with the same seed", n, i)
+				}
+			}
+		}
+	}
+}
+
+func TestSyntheticCamera(t *testing.T) {
+	camera := NewSyntheticCamera("center", 64, 48, 0, 7)
+	if err := camera.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var sequence uint64
+	for i := 0; i < 5; i++ {
+		frame := <-camera.Frames()
+		if frame.Source != "center" || frame.Sequence <= sequence || frame.Time.IsZero() {
+			t.Fatalf("frame %d is from %s #%d at %v", i, frame.Source, frame.Sequence, frame.Time)
+		}
+		sequence = frame.Sequence
+		if !bytes.Equal(frame.Frame.(*image.RGBA).Pix, camera.Render(int(frame.Sequence)-1).Pix) {
+			t.Fatalf("frame %d is not frame %d of the script", i, frame.Sequence-1)
+		}
+	}
+	if err := camera.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	// the frames are closed after the last buffered frame
+	for range camera.Frames() {
+	}
+}
+
+func TestSyntheticModeCamera(t *testing.T) {
+	camera := NewSyntheticModeCamera("left", CameraMode{Width: 64, Height: 48, Rate: 5}, 1)
+	if info := camera.Info(); info.Width != 64 || info.Height != 48 {
+		t.Fatalf("the camera is %dx%d, want 64x48", info.Width, info.Height)
+	}
+	if camera.Render(0).Bounds() != image.Rect(0, 0, 64, 48) {
+		t.Fatalf("the frames are %v", camera.Render(0).Bounds())
+	}
+	// a mode without a size gets the default size
+	camera = NewSyntheticModeCamera("right", CameraMode{}, 1)
+	if info := camera.Info(); info.Width != SyntheticWidth || info.Height != SyntheticHeight {
+		t.Fatalf("the default camera is %dx%d", info.Width, info.Height)
+	}
+}
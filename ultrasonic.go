@@ -0,0 +1,142 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// SpeedOfSound is the speed of sound in meters per second
+	SpeedOfSound = 343
+	// RangefinderPeriod is the time between pings, long enough for the echoes of the last ping to die out
+	RangefinderPeriod = 60 * time.Millisecond
+	// RangefinderMax is the longest distance in meters a HC-SR04 can measure
+	RangefinderMax = 4
+)
+
+// Rangefinder is a HC-SR04 style ultrasonic rangefinder, a pulse on the trigger line
+// sends a ping and the echo line is high for the round trip time of the ping
+type Rangefinder struct {
+	Trigger Line
+	// Window is the number of readings in the median filter
+	Window int
+
+	mutex    sync.Mutex
+	rise     time.Duration
+	readings []float64
+	distance float64
+	updated  time.Time
+	stream   chan float64
+	done     chan struct{}
+	wait     sync.WaitGroup
+}
+
+// NewRangefinder creates a new rangefinder, Echo must be called on the edges of the echo line
+func NewRangefinder(trigger Line, window int) *Rangefinder {
+	if window < 1 {
+		window = 1
+	}
+	return &Rangefinder{
+		Trigger: trigger,
+		Window:  window,
+		stream:  make(chan float64, 1),
+		done:    make(chan struct{}),
+	}
+}
+
+// Start starts pinging every RangefinderPeriod
+func (r *Rangefinder) Start() {
+	r.wait.Add(1)
+	go func() {
+		defer r.wait.Done()
+		t := time.NewTicker(RangefinderPeriod)
+		defer t.Stop()
+		for {
+			select {
+			case <-r.done:
+				return
+			case <-t.C:
+			}
+			r.Trigger.SetValue(1)
+			time.Sleep(10 * time.Microsecond)
+			r.Trigger.SetValue(0)
+		}
+	}()
+}
+
+// Echo measures the width of an echo pulse
+func (r *Rangefinder) Echo(edge Edge) {
+	if edge.Rising {
+		r.mutex.Lock()
+		r.rise = edge.Time
+		r.mutex.Unlock()
+		return
+	}
+	r.mutex.Lock()
+	if r.rise == 0 {
+		r.mutex.Unlock()
+		return
+	}
+	width := edge.Time - r.rise
+	r.rise = 0
+	r.mutex.Unlock()
+	r.Measure(width)
+}
+
+// Measure adds the distance of an echo pulse width to the filter
+func (r *Rangefinder) Measure(width time.Duration) {
+	distance := width.Seconds() * SpeedOfSound / 2
+	if distance <= 0 || distance > RangefinderMax {
+		// no echo came back
+		distance = math.Inf(1)
+	}
+	r.mutex.Lock()
+	r.readings = append(r.readings, distance)
+	if len(r.readings) > r.Window {
+		r.readings = r.readings[1:]
+	}
+	sorted := append([]float64{}, r.readings...)
+	sort.Float64s(sorted)
+	r.distance, r.updated = sorted[len(sorted)/2], time.Now()
+	distance = r.distance
+	r.mutex.Unlock()
+	select {
+	case r.stream <- distance:
+	default:
+	}
+}
+
+// Distance returns the filtered distance in meters, infinite when nothing is in range,
+// the second result is false when no echo has been measured recently
+func (r *Rangefinder) Distance() (float64, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.distance, !r.updated.IsZero() && time.Since(r.updated) < 5*RangefinderPeriod
+}
+
+// Distances is the stream of filtered distances
+func (r *Rangefinder) Distances() <-chan float64 {
+	return r.stream
+}
+
+// Close stops pinging and releases the trigger line
+func (r *Rangefinder) Close() error {
+	close(r.done)
+	r.wait.Wait()
+	return r.Trigger.Close()
+}
+
+// Veto replaces an action whose tracks move the robot forward with a stop when an obstacle
+// is closer than threshold meters, the actions are configurable so any action can have a forward component
+func Veto(action Action, tracks Tracks, distance, threshold float64) Action {
+	if tracks.Left+tracks.Right > 0 && distance < threshold {
+		return ActionStop
+	}
+	return action
+}
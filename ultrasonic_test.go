@@ -0,0 +1,102 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// echoWidth is the width of the echo pulse of an obstacle at distance meters
+func echoWidth(distance float64) time.Duration {
+	return time.Duration(2 * distance / SpeedOfSound * float64(time.Second))
+}
+
+func TestRangefinderMedian(t *testing.T) {
+	r := NewRangefinder(nil, 3)
+	if _, ok := r.Distance(); ok {
+		t.Fatal("ok before the first echo")
+	}
+	tests := []struct {
+		distance float64
+		want     float64
+	}{
+		{1, 1},
+		// a spike is filtered out once the window has a majority
+		{.2, 1},
+		{1.1, 1},
+		{1.2, 1.1},
+		// no echo is out of range
+		{RangefinderMax + 1, 1.2},
+		{RangefinderMax + 1, math.Inf(1)},
+	}
+	for i, test := range tests {
+		r.Measure(echoWidth(test.distance))
+		distance, ok := r.Distance()
+		if !ok {
+			t.Fatalf("reading %d is not ok", i)
+		}
+		if math.Abs(distance-test.want) > 1e-3 && !(math.IsInf(distance, 1) && math.IsInf(test.want, 1)) {
+			t.Fatalf("reading %d of %v m filters to %v m, want %v m", i, test.distance, distance, test.want)
+		}
+		if streamed := <-r.Distances(); streamed != distance {
+			t.Fatalf("reading %d streamed %v m, want %v m", i, streamed, distance)
+		}
+	}
+}
+
+func TestRangefinderEcho(t *testing.T) {
+	r := NewRangefinder(nil, 1)
+	// a falling edge without a rising edge is ignored
+	r.Echo(Edge{Rising: false, Time: time.Second})
+	if _, ok := r.Distance(); ok {
+		t.Fatal("a lone falling edge was measured")
+	}
+	r.Echo(Edge{Rising: true, Time: time.Second})
+	r.Echo(Edge{Rising: false, Time: time.Second + echoWidth(.5)})
+	if distance, ok := r.Distance(); !ok || math.Abs(distance-.5) > 1e-3 {
+		t.Fatalf("distance is %v m %v, want .5 m", distance, ok)
+	}
+}
+
+func TestRangefinderTimeout(t *testing.T) {
+	r := NewRangefinder(nil, 1)
+	r.Measure(echoWidth(1))
+	if _, ok := r.Distance(); !ok {
+		t.Fatal("not ok after an echo")
+	}
+	time.Sleep(6 * RangefinderPeriod)
+	if _, ok := r.Distance(); ok {
+		t.Fatal("still ok without a recent echo")
+	}
+}
+
+func TestVeto(t *testing.T) {
+	tests := []struct {
+		action   Action
+		tracks   Tracks
+		distance float64
+		want     Action
+	}{
+		{ActionForward, Tracks{Left: 1, Right: 1}, .1, ActionStop},
+		{ActionForward, Tracks{Left: 1, Right: 1}, .5, ActionForward},
+		{ActionForward, Tracks{Left: 1, Right: 1}, math.Inf(1), ActionForward},
+		// an arc drives forward too
+		{ActionLeft, Tracks{Left: .5, Right: 1}, .1, ActionStop},
+		{ActionRight, Tracks{Left: 1, Right: 0}, .1, ActionStop},
+		// turning in place does not move the robot forward
+		{ActionLeft, Tracks{Left: -1, Right: 1}, .1, ActionLeft},
+		// a turn that creeps forward is vetoed
+		{ActionRight, Tracks{Left: 1, Right: -.5}, .1, ActionStop},
+		{ActionStop, Tracks{}, .1, ActionStop},
+		{ActionBackward, Tracks{Left: -1, Right: -1}, .1, ActionBackward},
+	}
+	for _, test := range tests {
+		if action := Veto(test.action, test.tracks, test.distance, .3); action != test.want {
+			t.Fatalf("Veto(%d, %v, %v) = %d, want %d", test.action, test.tracks, test.distance, action, test.want)
+		}
+	}
+}
@@ -5,8 +5,9 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"image"
+	"math"
 	"runtime"
 	"sort"
 	"time"
@@ -36,42 +37,101 @@ func (slice FrameSizes) Swap(i, j int) {
 
 // V4LCamera is a camera that is from a v4l device
 type V4LCamera struct {
-	Stream bool
-	Images chan Frame
+	cameraStream
+	// Device is the path of the v4l device
+	Device string
+	// Formats are the pixel formats in order of preference
+	Formats []webcam.PixelFormat
+	// Mode is the requested frame size and rate
+	Mode CameraMode
+
+	decode Decoder
 }
 
-// NewV4LCamera creates a new v4l camera
-func NewV4LCamera() *V4LCamera {
+// NewV4LCamera creates a new v4l camera that captures as close to mode as the device allows
+func NewV4LCamera(name, device string, mode CameraMode) *V4LCamera {
 	return &V4LCamera{
-		Stream: true,
-		Images: make(chan Frame, 1),
+		cameraStream: newCameraStream(CameraInfo{
+			Name:   name,
+			Device: device,
+		}, mode.Rate),
+		Device:  device,
+		Formats: DefaultFormats,
+		Mode:    mode,
 	}
 }
 
-// Start starts streaming
-func (vc *V4LCamera) Start(device string) {
-	runtime.LockOSThread()
-	skip := 0
-	fmt.Println(device)
-	camera, err := webcam.Open(device)
+// ChooseFrameSize returns the supported frame size closest to width by height,
+// the smallest frame size is chosen when width or height is zero
+func ChooseFrameSize(sizes FrameSizes, width, height int) (uint32, uint32) {
+	sorted := append(FrameSizes{}, sizes...)
+	sort.Sort(sorted)
+	if width <= 0 || height <= 0 {
+		return sorted[0].MinWidth, sorted[0].MinHeight
+	}
+	// fit returns the closest value in a stepwise range, discrete sizes have no step
+	fit := func(min, max, step uint32, want int) uint32 {
+		if step == 0 || want >= int(max) {
+			return max
+		}
+		if want <= int(min) {
+			return min
+		}
+		return min + uint32(math.Round(float64(uint32(want)-min)/float64(step)))*step
+	}
+	distance := func(a uint32, b int) int {
+		d := int(a) - b
+		if d < 0 {
+			return -d
+		}
+		return d
+	}
+	best, w, h := -1, uint32(0), uint32(0)
+	for _, size := range sorted {
+		sw := fit(size.MinWidth, size.MaxWidth, size.StepWidth, width)
+		sh := fit(size.MinHeight, size.MaxHeight, size.StepHeight, height)
+		if d := distance(sw, width) + distance(sh, height); best < 0 || d < best {
+			best, w, h = d, sw, sh
+		}
+	}
+	return w, h
+}
+
+// Start opens the device and streams frames until ctx is canceled or Stop is called
+func (vc *V4LCamera) Start(ctx context.Context) error {
+	ctx, err := vc.begin(ctx)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	defer camera.Close()
+	camera, err := vc.open()
+	if err != nil {
+		vc.end(err)
+		return err
+	}
+	go func() {
+		err := vc.stream(ctx, camera)
+		if e := camera.Close(); err == nil {
+			err = e
+		}
+		vc.end(err)
+	}()
+	return nil
+}
 
-	format_desc := camera.GetSupportedFormats()
-	var formats []webcam.PixelFormat
-	for f := range format_desc {
-		formats = append(formats, f)
+// open opens the device, sets the smallest frame size and starts streaming
+func (vc *V4LCamera) open() (*webcam.Webcam, error) {
+	camera, err := webcam.Open(vc.Device)
+	if err != nil {
+		return nil, err
 	}
-	sort.Slice(formats, func(i, j int) bool {
-		return format_desc[formats[i]] < format_desc[formats[j]]
-	})
-	println("Available formats: ")
-	for i, value := range formats {
-		fmt.Printf("[%d] %s\n", i+1, format_desc[value])
+
+	format_desc := camera.GetSupportedFormats()
+	format, err := NegotiateFormat(format_desc, vc.Formats)
+	if err != nil {
+		camera.Close()
+		return nil, fmt.Errorf("%s: %w", vc.Device, err)
 	}
-	format := formats[1]
+	vc.decode = Decoders[format]
 
 	fmt.Printf("Supported frame sizes for format %s\n", format_desc[format])
 	frames := FrameSizes(camera.GetSupportedFrameSizes(format))
@@ -79,76 +139,92 @@ func (vc *V4LCamera) Start(device string) {
 	for i, value := range frames {
 		fmt.Printf("[%d] %s\n", i+1, value.GetString())
 	}
-	size := frames[0]
+	if len(frames) == 0 {
+		camera.Close()
+		return nil, fmt.Errorf("%s has no frame sizes for format %s", vc.Device, format_desc[format])
+	}
+	width, height := ChooseFrameSize(frames, vc.Mode.Width, vc.Mode.Height)
 
-	f, w, h, err := camera.SetImageFormat(format, uint32(size.MaxWidth), uint32(size.MaxHeight))
+	f, w, h, err := camera.SetImageFormat(format, width, height)
+	if err != nil {
+		camera.Close()
+		return nil, err
+	}
+	if f != format {
+		camera.Close()
+		return nil, fmt.Errorf("%s: asked for format %s, got %s", vc.Device, FormatName(format), FormatName(f))
+	}
+	if vc.Mode.FPS > 0 {
+		// sets the frame interval with VIDIOC_S_PARM
+		err = camera.SetFramerate(float32(vc.Mode.FPS))
+		if err != nil {
+			camera.Close()
+			return nil, fmt.Errorf("%s: setting %v fps: %w", vc.Device, vc.Mode.FPS, err)
+		}
+	}
+	fps, err := camera.GetFramerate()
 	if err != nil {
-		panic(err)
-	} else {
-		fmt.Printf("Resulting image format: %s (%dx%d)\n", format_desc[f], w, h)
+		// not every driver reports its frame interval
+		fps = 0
 	}
+	fmt.Printf("Resulting image format: %s (%dx%d)\n", format_desc[f], w, h)
+	vc.setInfo(CameraInfo{
+		Name:   vc.Info().Name,
+		Device: vc.Device,
+		Format: FormatName(f),
+		Width:  int(w),
+		Height: int(h),
+		FPS:    float64(fps),
+	})
 
 	err = camera.StartStreaming()
 	if err != nil {
-		panic(err)
+		camera.Close()
+		return nil, err
 	}
+	return camera, nil
+}
+
+// stream reads frames until ctx is canceled
+func (vc *V4LCamera) stream(ctx context.Context, camera *webcam.Webcam) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
 	defer camera.StopStreaming()
+	info := vc.Info()
 
-	var cp []byte
-	start, count := time.Now(), 0.0
-	_ = start
-	for vc.Stream {
-		err := camera.WaitForFrame(5)
+	for ctx.Err() == nil {
+		// a short wait checks for cancellation at least once a second
+		err := camera.WaitForFrame(1)
 
 		switch err.(type) {
 		case nil:
 		case *webcam.Timeout:
-			fmt.Println(device, err)
 			continue
 		default:
-			panic(err)
+			return err
 		}
 
 		frame, err := camera.ReadFrame()
 		if err != nil {
-			fmt.Println(device, err)
+			fmt.Println(vc.Device, err)
 			continue
-		} else {
-			//fmt.Println(device)
 		}
-		count++
 
-		//fmt.Println(device, count/float64(time.Since(start).Seconds()))
-
-		if skip < 20 {
-			skip++
-		} else {
-			skip = 0
+		if !vc.capture(time.Now()) {
 			continue
 		}
 		if len(frame) != 0 {
-			if len(cp) < len(frame) {
-				cp = make([]byte, len(frame))
-			}
-			copy(cp, frame)
-			//fmt.Printf("Frame: %d bytes\n", len(cp))
-			yuyv := image.NewYCbCr(image.Rect(0, 0, int(w), int(h)), image.YCbCrSubsampleRatio422)
-			for i := range yuyv.Cb {
-				ii := i * 4
-				yuyv.Y[i*2] = cp[ii]
-				yuyv.Y[i*2+1] = cp[ii+2]
-				yuyv.Cb[i] = cp[ii+1]
-				yuyv.Cr[i] = cp[ii+3]
-
+			// the frame is decoded before the buffer is reused by the next read
+			img, err := vc.decode(frame, info.Width, info.Height)
+			if err != nil {
+				fmt.Println(vc.Device, err)
+				continue
 			}
 
-			select {
-			case vc.Images <- Frame{
-				Frame: yuyv,
-			}:
-			default:
-				//fmt.Println("drop", device)
-			}
+			vc.send(Frame{
+				Frame: img,
+			})
 		}
 	}
+	return nil
 }
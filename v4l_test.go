@@ -0,0 +1,51 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/blackjack/webcam"
+)
+
+// discrete returns a discrete frame size
+func discrete(width, height uint32) webcam.FrameSize {
+	return webcam.FrameSize{MinWidth: width, MaxWidth: width, MinHeight: height, MaxHeight: height}
+}
+
+func TestChooseFrameSize(t *testing.T) {
+	sizes := FrameSizes{discrete(640, 480), discrete(320, 240), discrete(1280, 720)}
+	stepwise := FrameSizes{{
+		MinWidth: 160, MaxWidth: 1280, StepWidth: 16,
+		MinHeight: 120, MaxHeight: 960, StepHeight: 8,
+	}}
+	tests := []struct {
+		name          string
+		sizes         FrameSizes
+		width, height int
+		w, h          uint32
+	}{
+		{"exact", sizes, 640, 480, 640, 480},
+		{"nearest larger", sizes, 600, 450, 640, 480},
+		{"nearest smaller", sizes, 352, 288, 320, 240},
+		{"past the largest", sizes, 1920, 1080, 1280, 720},
+		{"smallest without a width", sizes, 0, 480, 320, 240},
+		{"smallest without a height", sizes, 640, 0, 320, 240},
+		{"stepwise exact", stepwise, 640, 480, 640, 480},
+		{"stepwise rounds to a step", stepwise, 650, 485, 656, 488},
+		{"stepwise below the minimum", stepwise, 100, 100, 160, 120},
+		{"stepwise past the maximum", stepwise, 1920, 1080, 1280, 960},
+		{"stepwise smallest", stepwise, 0, 0, 160, 120},
+	}
+	for _, test := range tests {
+		w, h := ChooseFrameSize(test.sizes, test.width, test.height)
+		if w != test.w || h != test.h {
+			t.Fatalf("%s: %dx%d chose %dx%d, want %dx%d", test.name, test.width, test.height, w, h, test.w, test.h)
+		}
+	}
+	if sizes[0] != discrete(640, 480) {
+		t.Fatal("the frame sizes were sorted in place")
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"sort"
+	"strings"
+
+	"github.com/blackjack/webcam"
+)
+
+// fourcc returns the v4l pixel format of a four character code
+func fourcc(code string) webcam.PixelFormat {
+	return webcam.PixelFormat(uint32(code[0]) | uint32(code[1])<<8 | uint32(code[2])<<16 | uint32(code[3])<<24)
+}
+
+var (
+	// FormatYUYV is packed 4:2:2 yuv
+	FormatYUYV = fourcc("YUYV")
+	// FormatMJPEG is motion jpeg
+	FormatMJPEG = fourcc("MJPG")
+	// FormatNV12 is 4:2:0 yuv with a y plane followed by an interleaved cb cr plane
+	FormatNV12 = fourcc("NV12")
+)
+
+// FormatName returns the four character code of a pixel format
+func FormatName(format webcam.PixelFormat) string {
+	return string([]byte{byte(format), byte(format >> 8), byte(format >> 16), byte(format >> 24)})
+}
+
+// Decoder decodes a v4l buffer of a pixel format into an image
+type Decoder func(data []byte, width, height int) (image.Image, error)
+
+// Decoders are the decoders of the supported pixel formats
+var Decoders = map[webcam.PixelFormat]Decoder{
+	FormatYUYV:  DecodeYUYV,
+	FormatMJPEG: DecodeMJPEG,
+	FormatNV12:  DecodeNV12,
+}
+
+// DefaultFormats are the pixel formats in order of preference, the uncompressed formats are cheapest to decode
+var DefaultFormats = []webcam.PixelFormat{FormatYUYV, FormatNV12, FormatMJPEG}
+
+// NegotiateFormat returns the first preferred format that the camera offers and that can be decoded
+func NegotiateFormat(offered map[webcam.PixelFormat]string, preferred []webcam.PixelFormat) (webcam.PixelFormat, error) {
+	for _, format := range preferred {
+		if _, ok := offered[format]; !ok {
+			continue
+		}
+		if _, ok := Decoders[format]; ok {
+			return format, nil
+		}
+	}
+	var names []string
+	for format, description := range offered {
+		names = append(names, fmt.Sprintf("%s (%s)", FormatName(format), description))
+	}
+	sort.Strings(names)
+	var wanted []string
+	for _, format := range preferred {
+		wanted = append(wanted, FormatName(format))
+	}
+	return 0, fmt.Errorf("no usable pixel format, want one of %s, camera offers %s",
+		strings.Join(wanted, ", "), strings.Join(names, ", "))
+}
+
+// DecodeYUYV decodes packed 4:2:2 yuv, each pair of pixels is y0 cb y1 cr
+func DecodeYUYV(data []byte, width, height int) (image.Image, error) {
+	if width%2 != 0 {
+		return nil, fmt.Errorf("yuyv width %d is odd", width)
+	}
+	if len(data) < 2*width*height {
+		return nil, fmt.Errorf("yuyv frame is %d bytes, want %d", len(data), 2*width*height)
+	}
+	yuyv := image.NewYCbCr(image.Rect(0, 0, width, height), image.YCbCrSubsampleRatio422)
+	for i := range yuyv.Cb {
+		ii := i * 4
+		yuyv.Y[i*2] = data[ii]
+		yuyv.Y[i*2+1] = data[ii+2]
+		yuyv.Cb[i] = data[ii+1]
+		yuyv.Cr[i] = data[ii+3]
+	}
+	return yuyv, nil
+}
+
+// DecodeNV12 decodes 4:2:0 yuv with a y plane followed by an interleaved cb cr plane
+func DecodeNV12(data []byte, width, height int) (image.Image, error) {
+	if width%2 != 0 || height%2 != 0 {
+		return nil, fmt.Errorf("nv12 size %dx%d is odd", width, height)
+	}
+	size := width * height
+	if len(data) < size+size/2 {
+		return nil, fmt.Errorf("nv12 frame is %d bytes, want %d", len(data), size+size/2)
+	}
+	nv12 := image.NewYCbCr(image.Rect(0, 0, width, height), image.YCbCrSubsampleRatio420)
+	copy(nv12.Y, data[:size])
+	chroma := data[size:]
+	for i := range nv12.Cb {
+		nv12.Cb[i] = chroma[2*i]
+		nv12.Cr[i] = chroma[2*i+1]
+	}
+	return nv12, nil
+}
+
+// DecodeMJPEG decodes a motion jpeg frame, the huffman tables that motion jpeg leaves out are added
+func DecodeMJPEG(data []byte, width, height int) (image.Image, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, fmt.Errorf("mjpeg frame does not start with a jpeg marker")
+	}
+	if !hasDHT(data) {
+		frame := make([]byte, 0, len(data)+len(mjpegDHT))
+		frame = append(frame, data[:2]...)
+		frame = append(frame, mjpegDHT...)
+		data = append(frame, data[2:]...)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("mjpeg: %w", err)
+	}
+	if b := img.Bounds(); b.Dx() != width || b.Dy() != height {
+		return nil, fmt.Errorf("mjpeg frame is %dx%d, want %dx%d", b.Dx(), b.Dy(), width, height)
+	}
+	return img, nil
+}
+
+// hasDHT returns true if a jpeg defines huffman tables before its scan
+func hasDHT(data []byte) bool {
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			return false
+		}
+		marker := data[i+1]
+		switch marker {
+		case 0xc4:
+			return true
+		case 0xda:
+			return false
+		}
+		i += 2 + (int(data[i+2])<<8 | int(data[i+3]))
+	}
+	return false
+}
+
+// mjpegDHT is the segment of the standard huffman tables of ITU T.81 annex K.3
+var mjpegDHT = func() []byte {
+	tables := []struct {
+		class byte
+		bits  [16]byte
+		value []byte
+	}{
+		{0x00, [16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+			[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}},
+		{0x10, [16]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125},
+			[]byte{
+				0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12,
+				0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+				0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08,
+				0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+				0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16,
+				0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+				0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39,
+				0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+				0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59,
+				0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+				0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79,
+				0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+				0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98,
+				0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+				0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6,
+				0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+				0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4,
+				0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+				0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea,
+				0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+				0xf9, 0xfa,
+			}},
+		{0x01, [16]byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+			[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}},
+		{0x11, [16]byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 119},
+			[]byte{
+				0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21,
+				0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+				0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91,
+				0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+				0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34,
+				0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+				0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38,
+				0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+				0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58,
+				0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+				0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78,
+				0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+				0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96,
+				0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+				0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4,
+				0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+				0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2,
+				0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+				0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9,
+				0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+				0xf9, 0xfa,
+			}},
+	}
+	var body []byte
+	for _, table := range tables {
+		body = append(body, table.class)
+		body = append(body, table.bits[:]...)
+		body = append(body, table.value...)
+	}
+	length := len(body) + 2
+	return append([]byte{0xff, 0xc4, byte(length >> 8), byte(length)}, body...)
+}()
@@ -0,0 +1,204 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"strings"
+	"testing"
+
+	"github.com/blackjack/webcam"
+)
+
+// testYCbCr are colors with their y, cb and cr
+var testYCbCr = []struct {
+	name      string
+	y, cb, cr byte
+	rgb       color.RGBA
+}{
+	{"red", 76, 85, 255, color.RGBA{255, 0, 0, 255}},
+	{"green", 150, 44, 21, color.RGBA{0, 255, 0, 255}},
+	{"blue", 29, 255, 107, color.RGBA{0, 0, 255, 255}},
+	{"white", 255, 128, 128, color.RGBA{255, 255, 255, 255}},
+	{"black", 0, 128, 128, color.RGBA{0, 0, 0, 255}},
+}
+
+// checkColor fails if a pixel of an image is not close to a color
+func checkColor(t *testing.T, img image.Image, x, y int, want color.RGBA, tolerance int) {
+	t.Helper()
+	r, g, b, _ := img.At(x, y).RGBA()
+	for i, c := range []int{int(r >> 8), int(g >> 8), int(b >> 8)} {
+		w := int([]uint8{want.R, want.G, want.B}[i])
+		if c-w > tolerance || w-c > tolerance {
+			t.Fatalf("pixel %d,%d is %d %d %d, want %v", x, y, r>>8, g>>8, b>>8, want)
+		}
+	}
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	h264 := fourcc("H264")
+	tests := []struct {
+		name      string
+		offered   []webcam.PixelFormat
+		preferred []webcam.PixelFormat
+		want      webcam.PixelFormat
+	}{
+		{"first preference", []webcam.PixelFormat{FormatMJPEG, FormatYUYV}, DefaultFormats, FormatYUYV},
+		{"only mjpeg", []webcam.PixelFormat{h264, FormatMJPEG}, DefaultFormats, FormatMJPEG},
+		{"preference order", []webcam.PixelFormat{FormatMJPEG, FormatYUYV}, []webcam.PixelFormat{FormatMJPEG, FormatYUYV}, FormatMJPEG},
+		// a preferred format without a decoder is passed over
+		{"no decoder", []webcam.PixelFormat{h264, FormatNV12}, []webcam.PixelFormat{h264, FormatNV12}, FormatNV12},
+	}
+	for _, test := range tests {
+		offered := make(map[webcam.PixelFormat]string)
+		for _, format := range test.offered {
+			offered[format] = FormatName(format)
+		}
+		format, err := NegotiateFormat(offered, test.preferred)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if format != test.want {
+			t.Fatalf("%s: negotiated %s, want %s", test.name, FormatName(format), FormatName(test.want))
+		}
+	}
+
+	_, err := NegotiateFormat(map[webcam.PixelFormat]string{h264: "H.264"}, DefaultFormats)
+	if err == nil {
+		t.Fatal("a camera without a usable format was accepted")
+	}
+	if message := err.Error(); !strings.Contains(message, "H264 (H.264)") || !strings.Contains(message, "YUYV, NV12, MJPG") {
+		t.Fatalf("the error %q does not list the formats", message)
+	}
+}
+
+func TestDecodeYUYV(t *testing.T) {
+	// each pair of pixels shares its chroma, the last pair is a white and a black pixel
+	red, green, blue, white := testYCbCr[0], testYCbCr[1], testYCbCr[2], testYCbCr[3]
+	data := []byte{
+		red.y, red.cb, red.y, red.cr, green.y, green.cb, green.y, green.cr,
+		blue.y, blue.cb, blue.y, blue.cr, 255, white.cb, 0, white.cr,
+	}
+	img, err := DecodeYUYV(data, 4, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [2][4]color.RGBA{
+		{red.rgb, red.rgb, green.rgb, green.rgb},
+		{blue.rgb, blue.rgb, white.rgb, testYCbCr[4].rgb},
+	}
+	for y := range want {
+		for x, c := range want[y] {
+			checkColor(t, img, x, y, c, 2)
+		}
+	}
+
+	if _, err := DecodeYUYV(data[:15], 4, 2); err == nil {
+		t.Fatal("a short frame was decoded")
+	}
+	if _, err := DecodeYUYV(data, 3, 2); err == nil {
+		t.Fatal("an odd width was decoded")
+	}
+}
+
+func TestDecodeNV12(t *testing.T) {
+	// each 2x2 block shares its chroma
+	red, green, blue, white := testYCbCr[0], testYCbCr[1], testYCbCr[2], testYCbCr[3]
+	data := []byte{
+		red.y, red.y, green.y, green.y,
+		red.y, red.y, green.y, green.y,
+		blue.y, blue.y, 255, 0,
+		blue.y, blue.y, 0, 255,
+		red.cb, red.cr, green.cb, green.cr,
+		blue.cb, blue.cr, white.cb, white.cr,
+	}
+	img, err := DecodeNV12(data, 4, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	black := testYCbCr[4].rgb
+	want := [4][4]color.RGBA{
+		{red.rgb, red.rgb, green.rgb, green.rgb},
+		{red.rgb, red.rgb, green.rgb, green.rgb},
+		{blue.rgb, blue.rgb, white.rgb, black},
+		{blue.rgb, blue.rgb, black, white.rgb},
+	}
+	for y := range want {
+		for x, c := range want[y] {
+			checkColor(t, img, x, y, c, 2)
+		}
+	}
+
+	if _, err := DecodeNV12(data[:23], 4, 4); err == nil {
+		t.Fatal("a short frame was decoded")
+	}
+	if _, err := DecodeNV12(data, 4, 3); err == nil {
+		t.Fatal("an odd height was decoded")
+	}
+}
+
+// stripDHT removes the huffman tables from a jpeg like a motion jpeg camera does
+func stripDHT(data []byte) []byte {
+	stripped := append([]byte{}, data[:2]...)
+	i := 2
+	for i+4 <= len(data) && data[i+1] != 0xda {
+		length := 2 + (int(data[i+2])<<8 | int(data[i+3]))
+		if data[i+1] != 0xc4 {
+			stripped = append(stripped, data[i:i+length]...)
+		}
+		i += length
+	}
+	return append(stripped, data[i:]...)
+}
+
+func TestDecodeMJPEG(t *testing.T) {
+	// four quadrants of color
+	src := image.NewRGBA(image.Rect(0, 0, 32, 16))
+	quadrants := []color.RGBA{testYCbCr[0].rgb, testYCbCr[1].rgb, testYCbCr[2].rgb, testYCbCr[3].rgb}
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 32; x++ {
+			src.SetRGBA(x, y, quadrants[2*(y/8)+x/16])
+		}
+	}
+	var buffer bytes.Buffer
+	if err := jpeg.Encode(&buffer, src, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	data := buffer.Bytes()
+	stripped := stripDHT(data)
+	if !hasDHT(data) || hasDHT(stripped) {
+		t.Fatal("the huffman tables were not found or not stripped")
+	}
+
+	img, err := DecodeMJPEG(data, 32, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range quadrants {
+		checkColor(t, img, 16*(i%2)+8, 8*(i/2)+4, c, 8)
+	}
+	// the encoder uses the standard tables, so the frame without them decodes to the same image
+	frame, err := DecodeMJPEG(stripped, 32, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 32; x++ {
+			if frame.At(x, y) != img.At(x, y) {
+				t.Fatalf("pixel %d,%d is %v without the huffman tables, want %v", x, y, frame.At(x, y), img.At(x, y))
+			}
+		}
+	}
+
+	if _, err := DecodeMJPEG(data, 16, 16); err == nil {
+		t.Fatal("a frame of the wrong size was decoded")
+	}
+	if _, err := DecodeMJPEG([]byte{0, 1, 2, 3}, 32, 16); err == nil {
+		t.Fatal("a frame without a jpeg marker was decoded")
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrEStop is returned for track commands while the emergency stop is latched
+var ErrEStop = errors.New("emergency stop is latched")
+
+// Watchdog is a differential drive that stops another drive when fresh commands
+// stop arriving and that latches an emergency stop
+type Watchdog struct {
+	Drive   DifferentialDrive
+	Timeout time.Duration
+
+	mutex   sync.Mutex
+	fed     time.Time
+	expired bool
+	latched bool
+	done    chan struct{}
+	wait    sync.WaitGroup
+}
+
+// NewWatchdog creates a new watchdog on top of drive that stops it when no command arrives within timeout
+func NewWatchdog(drive DifferentialDrive, timeout time.Duration) *Watchdog {
+	w := &Watchdog{
+		Drive:   drive,
+		Timeout: timeout,
+		fed:     time.Now(),
+		done:    make(chan struct{}),
+	}
+	w.wait.Add(1)
+	go w.run()
+	return w
+}
+
+// run checks for expired commands four times per timeout
+func (w *Watchdog) run() {
+	defer w.wait.Done()
+	t := time.NewTicker(w.Timeout / 4)
+	defer t.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case now := <-t.C:
+			w.mutex.Lock()
+			if !w.expired && now.Sub(w.fed) > w.Timeout {
+				w.expired = true
+				w.Drive.Stop()
+			}
+			w.mutex.Unlock()
+		}
+	}
+}
+
+// SetTracks sets the speed of the tracks and feeds the watchdog
+func (w *Watchdog) SetTracks(left, right float64) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.latched {
+		return ErrEStop
+	}
+	w.fed, w.expired = time.Now(), false
+	return w.Drive.SetTracks(left, right)
+}
+
+// Stop stops both tracks
+func (w *Watchdog) Stop() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.Drive.Stop()
+}
+
+// Expired returns true if the watchdog stopped the tracks because commands stopped arriving
+func (w *Watchdog) Expired() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.expired
+}
+
+// EStop stops both tracks and latches them stopped until Clear is called
+func (w *Watchdog) EStop() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.latched = true
+	return w.Drive.Stop()
+}
+
+// Clear releases a latched emergency stop
+func (w *Watchdog) Clear() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.latched = false
+}
+
+// Latched returns true if the emergency stop is latched
+func (w *Watchdog) Latched() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.latched
+}
+
+// Close stops the watchdog and closes the underlying drive
+func (w *Watchdog) Close() error {
+	close(w.done)
+	w.wait.Wait()
+	return w.Drive.Close()
+}
@@ -0,0 +1,130 @@
+// Copyright 2022 The Robot Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// testWatchdogTimeout is the watchdog timeout of the tests
+const testWatchdogTimeout = 100 * time.Millisecond
+
+// driving creates a watchdog over a ramping fake drive like OpenHardware and drives it forward
+func driving(t *testing.T) (*Watchdog, *FakeDrive) {
+	t.Helper()
+	fake := NewFakeDrive()
+	watchdog := NewWatchdog(NewRampDrive(fake, RampLimits{}), testWatchdogTimeout)
+	t.Cleanup(func() {
+		watchdog.Close()
+	})
+	if err := watchdog.SetTracks(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(testWatchdogTimeout / 2)
+	for fake.Current() != (Tracks{Left: 1, Right: 1}) {
+		if time.Now().After(deadline) {
+			t.Fatal("the tracks did not start")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return watchdog, fake
+}
+
+// released fails the test if the tracks of the fake drive are moving
+func released(t *testing.T, fake *FakeDrive) {
+	t.Helper()
+	if tracks := fake.Current(); tracks != (Tracks{}) {
+		t.Fatalf("the tracks are still driving at %v", tracks)
+	}
+}
+
+func TestWatchdogExpires(t *testing.T) {
+	watchdog, fake := driving(t)
+	if watchdog.Expired() {
+		t.Fatal("expired while fed")
+	}
+	time.Sleep(2 * testWatchdogTimeout)
+	released(t, fake)
+	if !watchdog.Expired() {
+		t.Fatal("not expired")
+	}
+	// a fresh command drives again
+	if err := watchdog.SetTracks(.5, .5); err != nil {
+		t.Fatal(err)
+	}
+	if watchdog.Expired() {
+		t.Fatal("still expired after a command")
+	}
+}
+
+func TestWatchdogFed(t *testing.T) {
+	watchdog, fake := driving(t)
+	for i := 0; i < 8; i++ {
+		time.Sleep(testWatchdogTimeout / 4)
+		watchdog.SetTracks(1, 1)
+	}
+	if watchdog.Expired() || fake.Current() != (Tracks{Left: 1, Right: 1}) {
+		t.Fatal("the watchdog stopped tracks that were fed")
+	}
+}
+
+func TestWatchdogEStop(t *testing.T) {
+	watchdog, fake := driving(t)
+	if err := watchdog.EStop(); err != nil {
+		t.Fatal(err)
+	}
+	released(t, fake)
+	if !watchdog.Latched() {
+		t.Fatal("not latched")
+	}
+	if err := watchdog.SetTracks(1, 1); err != ErrEStop {
+		t.Fatalf("a command while latched returned %v, want %v", err, ErrEStop)
+	}
+	time.Sleep(2 * RampPeriod)
+	released(t, fake)
+	watchdog.Clear()
+	if watchdog.Latched() {
+		t.Fatal("still latched after clear")
+	}
+	if err := watchdog.SetTracks(1, 1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fakeDevice is a joystick that is plugged in until it is unplugged
+type fakeDevice struct {
+	unplugged bool
+}
+
+// Attached returns true until the joystick is unplugged
+func (f *fakeDevice) Attached() bool {
+	return !f.unplugged
+}
+
+// TestWatchdogHeldStick is a stick held still, it sends no events and the main loop keeps repeating
+// the command until the joystick is unplugged
+func TestWatchdogHeldStick(t *testing.T) {
+	watchdog, fake := driving(t)
+	joystick := &fakeDevice{}
+	loop := func(ticks int) {
+		for i := 0; i < ticks; i++ {
+			if Repeat(ModeManual, joystick) {
+				watchdog.SetTracks(1, 1)
+			}
+			time.Sleep(16 * time.Millisecond)
+		}
+	}
+	loop(int(4 * testWatchdogTimeout / (16 * time.Millisecond)))
+	if watchdog.Expired() || fake.Current() != (Tracks{Left: 1, Right: 1}) {
+		t.Fatal("the watchdog stopped a held stick")
+	}
+	joystick.unplugged = true
+	loop(int(2 * testWatchdogTimeout / (16 * time.Millisecond)))
+	released(t, fake)
+	if !watchdog.Expired() {
+		t.Fatal("not expired after the joystick was unplugged")
+	}
+}